@@ -0,0 +1,155 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import "JaonedServer/utils"
+
+const checkpointColumns = "id, name, username, timestamp"
+
+func scanCheckpoint(row interface{ Scan(dest ...any) error }) *Checkpoint { // nillable
+    checkpoint := &Checkpoint{}
+    if row.Scan(&(checkpoint.Id), &(checkpoint.Name), &(checkpoint.Username), &(checkpoint.Timestamp)) != nil { return nil }
+    return checkpoint
+}
+
+func (impl *DatabaseImpl) AddCheckpoint(username []byte, board int32, name []byte) *Checkpoint { // nillable
+    if len(name) == 0 || len(name) > MaxCheckpointNameSize { return nil }
+
+    return scanCheckpoint(impl.db.QueryRow(
+        "insert into checkpoints(boardId, name, username, timestamp) select id, $2, $3, $4 from boards where id = $1 and deletedAt is null returning " + checkpointColumns,
+        board, name, username, utils.CurrentTimeMillis(),
+    ))
+}
+
+func (impl *DatabaseImpl) GetCheckpoint(board int32, id int32) *Checkpoint { // nillable
+    return scanCheckpoint(impl.db.QueryRow("select " + checkpointColumns + " from checkpoints where boardId = $1 and id = $2 and " + liveBoard, board, id))
+}
+
+func (impl *DatabaseImpl) GetCheckpoints(board int32) []*Checkpoint { // nillable
+    rows, err := impl.db.Query("select " + checkpointColumns + " from checkpoints where boardId = $1 and " + liveBoard + " order by timestamp, id", board)
+    if err != nil { return nil }
+
+    checkpoints := make([]*Checkpoint, 0)
+
+    for rows.Next() {
+        checkpoint := scanCheckpoint(rows)
+        if checkpoint == nil { return nil }
+        checkpoints = append(checkpoints, checkpoint)
+    }
+
+    return checkpoints
+}
+
+// brings the board back to how it looked at the checkpoint by adding, changing and removing elements,
+// all of that is recorded as a single batch so the later history stays intact and the restoration can be undone
+func (impl *DatabaseImpl) RestoreCheckpoint(username []byte, board int32, id int32) *Changes { // nillable
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    checkpoint := scanCheckpoint(tx.QueryRow("select " + checkpointColumns + " from checkpoints where boardId = $1 and id = $2", board, id))
+    if checkpoint == nil { return nil }
+
+    changes := &Changes{false, impl.nextSequence(tx, board), make([]*Element, 0), make([]*Tombstone, 0)}
+    if changes.Sequence < 0 { return nil }
+
+    past := impl.elementsAt(tx, board, checkpoint.Timestamp)
+    if past == nil { return nil }
+
+    present := impl.scanElements(tx.Query("select " + elementColumns + " from elements where boardId = $1 and removedAt is null", board))
+    if present == nil { return nil }
+
+    parent := impl.recordOperation(tx, &Operation{
+        Board: board,
+        Sequence: changes.Sequence,
+        Username: username,
+        Kind: OperationBatch,
+    })
+    if parent < 0 { return nil }
+
+    record := func(operation *Operation) bool {
+        operation.Board = board
+        operation.Sequence = changes.Sequence
+        operation.Username = username
+        operation.Parent = parent
+        return impl.recordOperation(tx, operation) >= 0
+    }
+
+    current := make(map[int32]*Element)
+    for _, element := range present { current[element.Id] = element }
+
+    kept := make(map[int32]bool)
+
+    for _, element := range past {
+        kept[element.Id] = true
+        existing := current[element.Id]
+
+        if existing == nil {
+            restored := impl.restoreElementRow(tx, board, &Element{Id: element.Id, Sequence: changes.Sequence, ZOrder: element.ZOrder, Type: element.Type, Bytes: element.Bytes})
+            if restored == nil { return nil }
+
+            if !record(&Operation{Kind: OperationAdd, ElementId: element.Id, ElementType: element.Type, After: element.Bytes, ZOrderAfter: element.ZOrder}) { return nil }
+            changes.Elements = append(changes.Elements, restored)
+            continue
+        }
+
+        var changed *Element = nil
+
+        if string(existing.Bytes) != string(element.Bytes) {
+            if changed = impl.updateElementRow(tx, board, element.Id, element.Bytes, changes.Sequence); changed == nil { continue } // a locked one stays as it is
+
+            if !record(&Operation{
+                Kind: OperationUpdate,
+                ElementId: element.Id,
+                ElementType: element.Type,
+                Before: existing.Bytes,
+                After: element.Bytes,
+                ZOrderBefore: existing.ZOrder,
+                ZOrderAfter: existing.ZOrder,
+            }) { return nil }
+        }
+
+        if existing.ZOrder != element.ZOrder {
+            if reordered := impl.reorderElementRow(tx, board, element.Id, element.ZOrder, changes.Sequence); reordered != nil {
+                changed = reordered
+
+                if !record(&Operation{
+                    Kind: OperationReorder,
+                    ElementId: element.Id,
+                    ElementType: element.Type,
+                    ZOrderBefore: existing.ZOrder,
+                    ZOrderAfter: element.ZOrder,
+                }) { return nil }
+            }
+        }
+
+        if changed != nil { changes.Elements = append(changes.Elements, changed) }
+    }
+
+    for _, element := range present {
+        if kept[element.Id] { continue }
+        if impl.removeElementRow(tx, username, board, element.Id, changes.Sequence) == nil { continue }
+
+        if !record(&Operation{Kind: OperationRemove, ElementId: element.Id, ElementType: element.Type, Before: element.Bytes, ZOrderBefore: element.ZOrder}) { return nil }
+        changes.Tombstones = append(changes.Tombstones, &Tombstone{element.Id, changes.Sequence})
+    }
+
+    if tx.Commit() != nil { return nil }
+    return changes
+}
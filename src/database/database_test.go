@@ -0,0 +1,67 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+    "os"
+    "testing"
+)
+
+// runs against the database JAONED_TEST_DATABASE_URL points to, skipped if it isn't set
+func testDatabase(t *testing.T) *DatabaseImpl {
+    url, exists := os.LookupEnv("JAONED_TEST_DATABASE_URL")
+    if !exists { t.Skip("JAONED_TEST_DATABASE_URL isn't set") }
+
+    return open(url)
+}
+
+func testBoard(t *testing.T, impl *DatabaseImpl, username []byte) int32 {
+    impl.RemoveUser(username)
+    if !impl.AddUser(username, []byte("password")) { t.Fatal("unable to add the user") }
+    t.Cleanup(func() { impl.RemoveUser(username) })
+
+    if !impl.AddBoard(username, &Board{Title: []byte("board")}) { t.Fatal("unable to add the board") }
+
+    var board int32
+    if impl.db.QueryRow("select id from boards where owner = $1", username).Scan(&board) != nil { t.Fatal("unable to find the board") }
+    t.Cleanup(func() { _, _ = impl.db.Exec("delete from boards where id = $1", board) })
+
+    return board
+}
+
+func TestAddElement(t *testing.T) {
+    impl := testDatabase(t)
+    defer impl.Close()
+
+    username := []byte("elementTester")
+    board := testBoard(t, impl, username)
+
+    first := impl.AddElement(username, Element{Type: ElementRectangle, Bytes: rectangle}, board)
+    second := impl.AddElement(username, Element{Type: ElementRectangle, Bytes: rectangle}, board)
+    if first == nil || second == nil { t.Fatal("unable to add keyless elements") }
+    if first.Id == second.Id { t.Error("keyless elements were deduplicated") }
+
+    key := []byte("0123456789abcdef")
+    keyed := impl.AddElement(username, Element{Type: ElementRectangle, Bytes: rectangle, Key: key}, board)
+    retried := impl.AddElement(username, Element{Type: ElementRectangle, Bytes: rectangle, Key: key}, board)
+    if keyed == nil || retried == nil { t.Fatal("unable to add keyed elements") }
+    if keyed.Id != retried.Id { t.Error("a retry wasn't deduplicated") }
+
+    if elements := impl.GetElements(board); len(elements) != 3 { t.Errorf("%d elements on the board, expected 3", len(elements)) }
+}
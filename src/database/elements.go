@@ -0,0 +1,427 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+    "bytes"
+    "math"
+    "unicode/utf8"
+    "unsafe"
+)
+
+// element bytes are laid out in the machine's byte order, the same way the messages are,
+// colors are packed argb, coordinates and sizes are 32-bit floats
+const (
+    MaxCoordinate = 1000000
+    MaxStrokeWidth = 1000
+    MaxFontSize = 1000
+    MaxPoints = 65536
+    MaxTextSize = 4096
+    MaxImageSize = 8 * 1024 * 1024
+)
+
+type ArrowHead int32
+
+const (
+    ArrowHeadNone ArrowHead = 0
+    ArrowHeadTriangle ArrowHead = 1
+    ArrowHeadOpen ArrowHead = 2
+    ArrowHeadCircle ArrowHead = 3
+)
+
+var (
+    pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+    jpegSignature = []byte{0xff, 0xd8, 0xff}
+)
+
+type Point struct {
+    X float32
+    Y float32
+}
+
+type ElementBody interface {
+    Type() ElementType
+    Valid() bool
+    Encode() []byte
+    Translate(dx float32, dy float32)
+}
+
+type PointsSet struct { // color, width, then points until the end
+    Color int32
+    Width float32
+    Points []Point
+}
+
+type Line struct { // color, width, start, end
+    Color int32
+    Width float32
+    Start Point
+    End Point
+}
+
+type Text struct { // color, font size, position, then utf-8 text until the end
+    Color int32
+    Size float32
+    Position Point
+    Text []byte
+}
+
+type Image struct { // position, width, height, then a png or jpeg file until the end
+    Position Point
+    Width float32
+    Height float32
+    Bytes []byte
+}
+
+type Shape struct { // stroke color, stroke width, fill color (fully transparent if not filled), position, size
+    Color int32
+    Width float32
+    Fill int32
+    Position Point
+    Size Point
+}
+
+type Rectangle struct {
+    Shape
+}
+
+type Ellipse struct { // inscribed in the shape's bounds
+    Shape
+}
+
+type Arrow struct { // color, width, start, end, start's head, end's head
+    Color int32
+    Width float32
+    Start Point
+    End Point
+    StartHead ArrowHead
+    EndHead ArrowHead
+}
+
+type StickyNote struct { // background color, text color, font size, position, size, then utf-8 text until the end
+    Background int32
+    Color int32
+    FontSize float32
+    Position Point
+    Size Point
+    Text []byte
+}
+
+type elementReader struct {
+    bytes []byte
+    offset int
+    failed bool
+}
+
+func (reader *elementReader) read(destination unsafe.Pointer, size int) {
+    if reader.failed || reader.offset + size > len(reader.bytes) {
+        reader.failed = true
+        return
+    }
+
+    copy(unsafe.Slice((*byte) (destination), size), unsafe.Slice(&(reader.bytes[reader.offset]), size))
+    reader.offset += size
+}
+
+func (reader *elementReader) int32() int32 {
+    var value int32
+    reader.read(unsafe.Pointer(&value), 4)
+    return value
+}
+
+func (reader *elementReader) float32() float32 {
+    var value float32
+    reader.read(unsafe.Pointer(&value), 4)
+    return value
+}
+
+func (reader *elementReader) point() Point {
+    return Point{reader.float32(), reader.float32()}
+}
+
+func (reader *elementReader) shape() Shape {
+    return Shape{reader.int32(), reader.float32(), reader.int32(), reader.point(), reader.point()}
+}
+
+func (reader *elementReader) rest() []byte {
+    if reader.failed { return nil }
+
+    rest := reader.bytes[reader.offset:]
+    reader.offset = len(reader.bytes)
+    return rest
+}
+
+type elementWriter struct {
+    bytes []byte
+}
+
+func (writer *elementWriter) write(source unsafe.Pointer, size int) {
+    writer.bytes = append(writer.bytes, unsafe.Slice((*byte) (source), size)...)
+}
+
+func (writer *elementWriter) int32(value int32) { writer.write(unsafe.Pointer(&value), 4) }
+func (writer *elementWriter) float32(value float32) { writer.write(unsafe.Pointer(&value), 4) }
+func (writer *elementWriter) point(value Point) { writer.float32(value.X); writer.float32(value.Y) }
+
+func (writer *elementWriter) shape(value *Shape) {
+    writer.int32(value.Color)
+    writer.float32(value.Width)
+    writer.int32(value.Fill)
+    writer.point(value.Position)
+    writer.point(value.Size)
+}
+
+func DecodeElement(xType ElementType, bytes []byte) ElementBody { // nillable - unknown type, malformed or invalid
+    reader := &elementReader{bytes, 0, false}
+    var body ElementBody
+
+    switch xType {
+        case ElementPointsSet:
+            pointsSet := &PointsSet{reader.int32(), reader.float32(), make([]Point, 0)}
+            for !reader.failed && reader.offset < len(bytes) && len(pointsSet.Points) <= MaxPoints {
+                pointsSet.Points = append(pointsSet.Points, reader.point())
+            }
+            body = pointsSet
+        case ElementLine:
+            body = &Line{reader.int32(), reader.float32(), reader.point(), reader.point()}
+        case ElementText:
+            body = &Text{reader.int32(), reader.float32(), reader.point(), reader.rest()}
+        case ElementImage:
+            body = &Image{reader.point(), reader.float32(), reader.float32(), reader.rest()}
+        case ElementRectangle:
+            body = &Rectangle{reader.shape()}
+        case ElementEllipse:
+            body = &Ellipse{reader.shape()}
+        case ElementArrow:
+            body = &Arrow{reader.int32(), reader.float32(), reader.point(), reader.point(), ArrowHead(reader.int32()), ArrowHead(reader.int32())}
+        case ElementStickyNote:
+            body = &StickyNote{reader.int32(), reader.int32(), reader.float32(), reader.point(), reader.point(), reader.rest()}
+        default:
+            return nil
+    }
+
+    if reader.failed || reader.offset != len(bytes) || !body.Valid() { return nil }
+    return body
+}
+
+func ValidElement(xType ElementType, bytes []byte) bool {
+    return DecodeElement(xType, bytes) != nil
+}
+
+func TranslateElement(xType ElementType, bytes []byte, dx float32, dy float32) []byte { // nillable - the element is invalid either before or after moving
+    body := DecodeElement(xType, bytes)
+    if body == nil { return nil }
+
+    body.Translate(dx, dy)
+    if !body.Valid() { return nil }
+
+    return body.Encode()
+}
+
+func (point *Point) translate(dx float32, dy float32) {
+    point.X += dx
+    point.Y += dy
+}
+
+func validCoordinate(value float32) bool {
+    return !math.IsNaN(float64(value)) && math.Abs(float64(value)) <= MaxCoordinate
+}
+
+func validPoint(point Point) bool {
+    return validCoordinate(point.X) && validCoordinate(point.Y)
+}
+
+func validSize(value float32, max float32) bool {
+    return !math.IsNaN(float64(value)) && value > 0 && value <= max
+}
+
+func validColor(color int32) bool {
+    return uint32(color) >> 24 != 0 // a fully transparent element would be invisible
+}
+
+func (pointsSet *PointsSet) Type() ElementType { return ElementPointsSet }
+
+func (pointsSet *PointsSet) Valid() bool {
+    if !validColor(pointsSet.Color) || !validSize(pointsSet.Width, MaxStrokeWidth) { return false }
+    if len(pointsSet.Points) == 0 || len(pointsSet.Points) > MaxPoints { return false }
+
+    for _, point := range pointsSet.Points {
+        if !validPoint(point) { return false }
+    }
+    return true
+}
+
+func (pointsSet *PointsSet) Encode() []byte {
+    writer := &elementWriter{make([]byte, 0, 4 + 4 + len(pointsSet.Points) * 8)}
+    writer.int32(pointsSet.Color)
+    writer.float32(pointsSet.Width)
+    for _, point := range pointsSet.Points { writer.point(point) }
+    return writer.bytes
+}
+
+func (pointsSet *PointsSet) Translate(dx float32, dy float32) {
+    for index := range pointsSet.Points { pointsSet.Points[index].translate(dx, dy) }
+}
+
+func (line *Line) Type() ElementType { return ElementLine }
+
+func (line *Line) Valid() bool {
+    return validColor(line.Color) && validSize(line.Width, MaxStrokeWidth) && validPoint(line.Start) && validPoint(line.End)
+}
+
+func (line *Line) Encode() []byte {
+    writer := &elementWriter{make([]byte, 0, 4 + 4 + 8 + 8)}
+    writer.int32(line.Color)
+    writer.float32(line.Width)
+    writer.point(line.Start)
+    writer.point(line.End)
+    return writer.bytes
+}
+
+func (line *Line) Translate(dx float32, dy float32) {
+    line.Start.translate(dx, dy)
+    line.End.translate(dx, dy)
+}
+
+func (text *Text) Type() ElementType { return ElementText }
+
+func (text *Text) Valid() bool {
+    return validColor(text.Color) &&
+        validSize(text.Size, MaxFontSize) &&
+        validPoint(text.Position) &&
+        len(text.Text) > 0 && len(text.Text) <= MaxTextSize &&
+        utf8.Valid(text.Text)
+}
+
+func (text *Text) Encode() []byte {
+    writer := &elementWriter{make([]byte, 0, 4 + 4 + 8 + len(text.Text))}
+    writer.int32(text.Color)
+    writer.float32(text.Size)
+    writer.point(text.Position)
+    writer.bytes = append(writer.bytes, text.Text...)
+    return writer.bytes
+}
+
+func (text *Text) Translate(dx float32, dy float32) { text.Position.translate(dx, dy) }
+
+func (image *Image) Type() ElementType { return ElementImage }
+
+func (image *Image) Valid() bool {
+    return validPoint(image.Position) &&
+        validSize(image.Width, MaxCoordinate) &&
+        validSize(image.Height, MaxCoordinate) &&
+        len(image.Bytes) <= MaxImageSize &&
+        (bytes.HasPrefix(image.Bytes, pngSignature) || bytes.HasPrefix(image.Bytes, jpegSignature))
+}
+
+func (image *Image) Encode() []byte {
+    writer := &elementWriter{make([]byte, 0, 8 + 4 + 4 + len(image.Bytes))}
+    writer.point(image.Position)
+    writer.float32(image.Width)
+    writer.float32(image.Height)
+    writer.bytes = append(writer.bytes, image.Bytes...)
+    return writer.bytes
+}
+
+func (image *Image) Translate(dx float32, dy float32) { image.Position.translate(dx, dy) }
+
+func (shape *Shape) valid() bool {
+    return validColor(shape.Color) &&
+        validSize(shape.Width, MaxStrokeWidth) &&
+        validPoint(shape.Position) &&
+        validSize(shape.Size.X, MaxCoordinate) &&
+        validSize(shape.Size.Y, MaxCoordinate)
+}
+
+func (shape *Shape) Translate(dx float32, dy float32) { shape.Position.translate(dx, dy) }
+
+func (rectangle *Rectangle) Type() ElementType { return ElementRectangle }
+func (rectangle *Rectangle) Valid() bool { return rectangle.valid() }
+
+func (rectangle *Rectangle) Encode() []byte {
+    writer := &elementWriter{make([]byte, 0, 4 + 4 + 4 + 8 + 8)}
+    writer.shape(&(rectangle.Shape))
+    return writer.bytes
+}
+
+func (ellipse *Ellipse) Type() ElementType { return ElementEllipse }
+func (ellipse *Ellipse) Valid() bool { return ellipse.valid() }
+
+func (ellipse *Ellipse) Encode() []byte {
+    writer := &elementWriter{make([]byte, 0, 4 + 4 + 4 + 8 + 8)}
+    writer.shape(&(ellipse.Shape))
+    return writer.bytes
+}
+
+func validArrowHead(head ArrowHead) bool {
+    return head >= ArrowHeadNone && head <= ArrowHeadCircle
+}
+
+func (arrow *Arrow) Type() ElementType { return ElementArrow }
+
+func (arrow *Arrow) Valid() bool {
+    return validColor(arrow.Color) &&
+        validSize(arrow.Width, MaxStrokeWidth) &&
+        validPoint(arrow.Start) &&
+        validPoint(arrow.End) &&
+        validArrowHead(arrow.StartHead) &&
+        validArrowHead(arrow.EndHead)
+}
+
+func (arrow *Arrow) Encode() []byte {
+    writer := &elementWriter{make([]byte, 0, 4 + 4 + 8 + 8 + 4 + 4)}
+    writer.int32(arrow.Color)
+    writer.float32(arrow.Width)
+    writer.point(arrow.Start)
+    writer.point(arrow.End)
+    writer.int32(int32(arrow.StartHead))
+    writer.int32(int32(arrow.EndHead))
+    return writer.bytes
+}
+
+func (arrow *Arrow) Translate(dx float32, dy float32) {
+    arrow.Start.translate(dx, dy)
+    arrow.End.translate(dx, dy)
+}
+
+func (stickyNote *StickyNote) Type() ElementType { return ElementStickyNote }
+
+func (stickyNote *StickyNote) Valid() bool {
+    return validColor(stickyNote.Background) &&
+        validColor(stickyNote.Color) &&
+        validSize(stickyNote.FontSize, MaxFontSize) &&
+        validPoint(stickyNote.Position) &&
+        validSize(stickyNote.Size.X, MaxCoordinate) &&
+        validSize(stickyNote.Size.Y, MaxCoordinate) &&
+        len(stickyNote.Text) <= MaxTextSize && // an empty note is fine
+        utf8.Valid(stickyNote.Text)
+}
+
+func (stickyNote *StickyNote) Encode() []byte {
+    writer := &elementWriter{make([]byte, 0, 4 + 4 + 4 + 8 + 8 + len(stickyNote.Text))}
+    writer.int32(stickyNote.Background)
+    writer.int32(stickyNote.Color)
+    writer.float32(stickyNote.FontSize)
+    writer.point(stickyNote.Position)
+    writer.point(stickyNote.Size)
+    writer.bytes = append(writer.bytes, stickyNote.Text...)
+    return writer.bytes
+}
+
+func (stickyNote *StickyNote) Translate(dx float32, dy float32) { stickyNote.Position.translate(dx, dy) }
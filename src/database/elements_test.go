@@ -0,0 +1,110 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+    "encoding/binary"
+    "math"
+    "reflect"
+    "testing"
+)
+
+type payload []byte
+
+func (bytes payload) int32(value int32) payload { return binary.NativeEndian.AppendUint32(bytes, uint32(value)) }
+func (bytes payload) float32(value float32) payload { return binary.NativeEndian.AppendUint32(bytes, math.Float32bits(value)) }
+
+const solidRed = int32(-65536) // 0xffff0000
+
+func (bytes payload) point(x float32, y float32) payload { return bytes.float32(x).float32(y) }
+func (bytes payload) raw(values ...byte) payload { return append(bytes, values...) }
+
+// payloads follow the layouts described in elements.go
+var (
+    pointsSet = payload{}.int32(solidRed).float32(3).point(0, 0).point(10, 5).point(20, -5)
+    line = payload{}.int32(solidRed).float32(1).point(-10, 0).point(10, 0)
+    text = payload{}.int32(solidRed).float32(14).point(5, 5).raw([]byte("hello, \u043c\u0438\u0440")...)
+    image = payload{}.point(0, 0).float32(64).float32(32).raw(0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 13)
+    rectangle = payload{}.int32(solidRed).float32(2).int32(0).point(10, 20).point(100, 50)
+    arrow = payload{}.int32(solidRed).float32(2).point(0, 0).point(10, 10).int32(int32(ArrowHeadNone)).int32(int32(ArrowHeadTriangle))
+    stickyNote = payload{}.int32(solidRed).int32(solidRed).float32(12).point(0, 0).point(100, 100).raw([]byte("note")...)
+)
+
+func TestValidElement(t *testing.T) {
+    tests := []struct {
+        name string
+        xType ElementType
+        bytes []byte
+        valid bool
+    }{
+        {"points set", ElementPointsSet, pointsSet, true},
+        {"points set without points", ElementPointsSet, pointsSet[:8], false},
+        {"points set with half a point", ElementPointsSet, pointsSet[:len(pointsSet) - 4], false},
+        {"points set too far out", ElementPointsSet, payload{}.int32(solidRed).float32(3).point(MaxCoordinate * 2, 0), false},
+        {"line", ElementLine, line, true},
+        {"transparent line", ElementLine, payload{}.int32(0x00ffffff).float32(1).point(-10, 0).point(10, 0), false},
+        {"line without a width", ElementLine, payload{}.int32(solidRed).float32(0).point(-10, 0).point(10, 0), false},
+        {"text", ElementText, text, true},
+        {"empty text", ElementText, text[:16], false},
+        {"malformed text", ElementText, append(append(payload{}, text[:16]...), 0xff, 0xfe), false},
+        {"image", ElementImage, image, true},
+        {"jpeg image", ElementImage, payload{}.point(0, 0).float32(64).float32(32).raw(0xff, 0xd8, 0xff, 0xe0), true},
+        {"image of an unknown format", ElementImage, payload{}.point(0, 0).float32(64).float32(32).raw('G', 'I', 'F', '8'), false},
+        {"rectangle", ElementRectangle, rectangle, true},
+        {"truncated rectangle", ElementRectangle, rectangle[:len(rectangle) - 1], false},
+        {"rectangle with trailing bytes", ElementRectangle, append(append(payload{}, rectangle...), 0), false},
+        {"transparent rectangle", ElementRectangle, payload{}.int32(0).float32(2).int32(0).point(10, 20).point(100, 50), false},
+        {"ellipse", ElementEllipse, rectangle, true},
+        {"arrow", ElementArrow, arrow, true},
+        {"arrow with an unknown head", ElementArrow, append(payload{}, arrow[:len(arrow) - 4]...).int32(9), false},
+        {"sticky note", ElementStickyNote, stickyNote, true},
+        {"sticky note with malformed text", ElementStickyNote, append(append(payload{}, stickyNote...), 0xff), false},
+        {"unknown type", ElementType(100), rectangle, false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if valid := ValidElement(test.xType, test.bytes); valid != test.valid { t.Errorf("valid %t, expected %t", valid, test.valid) }
+        })
+    }
+}
+
+func TestTranslateElement(t *testing.T) {
+    tests := []struct {
+        name string
+        xType ElementType
+        bytes []byte
+        moved []byte
+    }{
+        {"points set", ElementPointsSet, pointsSet, payload{}.int32(solidRed).float32(3).point(5, -10).point(15, -5).point(25, -15)},
+        {"line", ElementLine, line, payload{}.int32(solidRed).float32(1).point(-5, -10).point(15, -10)},
+        {"text", ElementText, text, append(payload{}.int32(solidRed).float32(14).point(10, -5), text[16:]...)},
+        {"image", ElementImage, image, append(payload{}.point(5, -10).float32(64).float32(32), image[16:]...)},
+        {"rectangle", ElementRectangle, rectangle, payload{}.int32(solidRed).float32(2).int32(0).point(15, 10).point(100, 50)},
+        {"arrow", ElementArrow, arrow, payload{}.int32(solidRed).float32(2).point(5, -10).point(15, 0).int32(int32(ArrowHeadNone)).int32(int32(ArrowHeadTriangle))},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if moved := TranslateElement(test.xType, test.bytes, 5, -10); !reflect.DeepEqual(moved, test.moved) { t.Errorf("moved to %v, expected %v", moved, test.moved) }
+        })
+    }
+
+    if TranslateElement(ElementRectangle, rectangle, MaxCoordinate * 2, 0) != nil { t.Error("moved out of bounds") }
+}
@@ -0,0 +1,205 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import "database/sql"
+
+const (
+    folderColumns = "f.id, coalesce(f.parentId, 0), coalesce(f.workspaceId, 0), f.name"
+    accessibleFolder = "(f.owner = $1 or exists(select 1 from workspaceMembers wm where wm.workspaceId = f.workspaceId and wm.username = $1))" // expects the username as the first parameter
+)
+
+func (impl *DatabaseImpl) AddWorkspace(username []byte, name []byte) *Workspace { // nillable
+    if len(name) == 0 || len(name) > MaxFolderNameSize { return nil }
+
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    workspace := &Workspace{Name: name, Owner: username}
+    if tx.QueryRow("insert into workspaces(name, owner) values($1, $2) returning id", name, username).Scan(&(workspace.Id)) != nil { return nil }
+
+    _, err = tx.Exec("insert into workspaceMembers(workspaceId, username) values($1, $2)", workspace.Id, username)
+    if err != nil { return nil }
+
+    if tx.Commit() != nil { return nil }
+    return workspace
+}
+
+func (impl *DatabaseImpl) AddWorkspaceMember(username []byte, workspace int32, member []byte) bool {
+    result, err := impl.db.Exec(`
+        insert into workspaceMembers(workspaceId, username) select w.id, u.username from workspaces w, users u
+        where w.id = $1 and w.owner = $2 and u.username = $3 on conflict(workspaceId, username) do nothing
+    `, workspace, username, member)
+    if err != nil { return false }
+
+    affected, err := result.RowsAffected()
+    return err == nil && affected > 0
+}
+
+func (impl *DatabaseImpl) GetWorkspaces(username []byte) []*Workspace { // nillable
+    rows, err := impl.db.Query(
+        "select w.id, w.name, w.owner from workspaces w inner join workspaceMembers wm on w.id = wm.workspaceId where wm.username = $1 order by w.name, w.id",
+        username,
+    )
+    if err != nil { return nil }
+
+    workspaces := make([]*Workspace, 0)
+
+    for rows.Next() {
+        workspace := &Workspace{}
+        if rows.Scan(&(workspace.Id), &(workspace.Name), &(workspace.Owner)) != nil { return nil }
+        workspaces = append(workspaces, workspace)
+    }
+
+    return workspaces
+}
+
+func (impl *DatabaseImpl) folderWorkspace(tx *sql.Tx, username []byte, id int32) int32 { // negative if the folder isn't accessible to the user
+    var workspace int32
+    if tx.QueryRow("select coalesce(f.workspaceId, 0) from folders f where f.id = $2 and " + accessibleFolder + " for update", username, id).Scan(&workspace) != nil { return -1 }
+    return workspace
+}
+
+func (impl *DatabaseImpl) AddFolder(username []byte, folder *Folder) *Folder { // nillable
+    if len(folder.Name) == 0 || len(folder.Name) > MaxFolderNameSize { return nil }
+
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    if folder.Workspace != 0 {
+        var member bool
+        if tx.QueryRow("select exists(select 1 from workspaceMembers where workspaceId = $1 and username = $2)", folder.Workspace, username).Scan(&member) != nil || !member { return nil }
+    }
+
+    if folder.Parent != 0 && impl.folderWorkspace(tx, username, folder.Parent) != folder.Workspace { return nil }
+
+    var owner []byte = nil
+    if folder.Workspace == 0 { owner = username }
+
+    added := *folder
+    if tx.QueryRow(
+        "insert into folders(name, parentId, owner, workspaceId) values($1, nullif($2, 0), $3, nullif($4, 0)) returning id",
+        folder.Name, folder.Parent, nullable(owner), folder.Workspace,
+    ).Scan(&(added.Id)) != nil { return nil }
+
+    if tx.Commit() != nil { return nil }
+    return &added
+}
+
+func (impl *DatabaseImpl) RenameFolder(username []byte, id int32, name []byte) bool {
+    if len(name) == 0 || len(name) > MaxFolderNameSize { return false }
+
+    result, err := impl.db.Exec("update folders f set name = $3 where f.id = $2 and " + accessibleFolder, username, id, name)
+    if err != nil { return false }
+
+    affected, err := result.RowsAffected()
+    return err == nil && affected > 0
+}
+
+func (impl *DatabaseImpl) MoveFolder(username []byte, id int32, parent int32) bool {
+    tx, err := impl.db.Begin()
+    if err != nil { return false }
+    defer tx.Rollback()
+
+    workspace := impl.folderWorkspace(tx, username, id)
+    if workspace < 0 { return false }
+
+    if parent != 0 {
+        if impl.folderWorkspace(tx, username, parent) != workspace { return false }
+
+        var cycle bool // the folder can't be moved into itself or any of its descendants
+        if tx.QueryRow(`
+            with recursive descendants(id) as (select $1::int union select f.id from folders f inner join descendants d on f.parentId = d.id)
+            select exists(select 1 from descendants where id = $2)
+        `, id, parent).Scan(&cycle) != nil || cycle { return false }
+    }
+
+    _, err = tx.Exec("update folders set parentId = nullif($1, 0) where id = $2", parent, id)
+    if err != nil { return false }
+
+    return tx.Commit() == nil
+}
+
+func (impl *DatabaseImpl) GetFolders(username []byte, workspace int32, parent int32) []*Folder { // nillable
+    rows, err := impl.db.Query(
+        "select " + folderColumns + " from folders f where coalesce(f.workspaceId, 0) = $2 and coalesce(f.parentId, 0) = $3 and " + accessibleFolder + " order by f.name, f.id",
+        username, workspace, parent,
+    )
+    if err != nil { return nil }
+
+    folders := make([]*Folder, 0)
+
+    for rows.Next() {
+        folder := &Folder{}
+        if rows.Scan(&(folder.Id), &(folder.Parent), &(folder.Workspace), &(folder.Name)) != nil { return nil }
+        folders = append(folders, folder)
+    }
+
+    return folders
+}
+
+func (impl *DatabaseImpl) PlaceBoard(username []byte, board int32, folder int32) bool {
+    tx, err := impl.db.Begin()
+    if err != nil { return false }
+    defer tx.Rollback()
+
+    workspace := impl.folderWorkspace(tx, username, folder)
+    if workspace < 0 { return false }
+
+    var member bool
+    if tx.QueryRow(
+        "select exists(select 1 from boards b inner join userAndBoard uab on b.id = uab.boardId where uab.username = $1 and b.id = $2 and b.deletedAt is null)",
+        username, board,
+    ).Scan(&member) != nil || !member { return false }
+
+    _, err = tx.Exec(
+        "delete from folderBoards where boardId = $2 and folderId in (select f.id from folders f where coalesce(f.workspaceId, 0) = $3 and " + accessibleFolder + ")",
+        username, board, workspace,
+    )
+    if err != nil { return false }
+
+    _, err = tx.Exec("insert into folderBoards(folderId, boardId) values($1, $2)", folder, board)
+    if err != nil { return false }
+
+    return tx.Commit() == nil
+}
+
+func (impl *DatabaseImpl) GetFolderBoards(username []byte, folder int32) []*Board { // nillable - only the boards the user is a member of
+    rows, err := impl.db.Query(`
+        select b.id, b.color, b.title from boards b
+        inner join folderBoards fb on b.id = fb.boardId
+        inner join folders f on f.id = fb.folderId
+        inner join userAndBoard uab on b.id = uab.boardId and uab.username = $1
+        where f.id = $2 and b.deletedAt is null and ` + accessibleFolder + " order by b.title, b.id",
+        username, folder,
+    )
+    if err != nil { return nil }
+
+    boards := make([]*Board, 0)
+
+    for rows.Next() {
+        board := &Board{}
+        if rows.Scan(&(board.Id), &(board.Color), &(board.Title)) != nil { return nil }
+        boards = append(boards, board)
+    }
+
+    return boards
+}
@@ -0,0 +1,124 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import "database/sql"
+
+// copies the source's layers and the given elements of it into the target, keeping their layers, groups, order and authorship,
+// the copies' additions are recorded already discarded so the target's history starts with them but they can't be undone
+func (impl *DatabaseImpl) copyBoardContents(tx *sql.Tx, username []byte, source int32, target int32, elements []*Element) bool {
+    sequence := impl.nextSequence(tx, target)
+    if sequence < 0 { return false }
+
+    layers := make(map[int32]int32)
+    layers[0] = 0
+
+    rows, err := tx.Query("select id, name, visible, locked, layerOrder from layers where boardId = $1", source)
+    if err != nil { return false }
+
+    sourceLayers := make([]*Layer, 0)
+    for rows.Next() {
+        layer := &Layer{}
+        if rows.Scan(&(layer.Id), &(layer.Name), &(layer.Visible), &(layer.Locked), &(layer.Order)) != nil { return false }
+        sourceLayers = append(sourceLayers, layer)
+    }
+
+    for _, layer := range sourceLayers {
+        var id int32
+        if tx.QueryRow(
+            "insert into layers(boardId, name, visible, locked, layerOrder) values($1, $2, $3, $4, $5) returning id",
+            target, layer.Name, layer.Visible, layer.Locked, layer.Order,
+        ).Scan(&id) != nil { return false }
+        layers[layer.Id] = id
+    }
+
+    groups := make(map[int32]int32)
+    groups[0] = 0
+
+    for _, element := range elements {
+        group, exists := groups[element.Group]
+        if !exists {
+            if tx.QueryRow("insert into elementGroups(boardId) values($1) returning id", target).Scan(&group) != nil { return false }
+            groups[element.Group] = group
+        }
+
+        author := element.Author
+        if author == nil { author = username }
+
+        var id int32
+        if tx.QueryRow(`
+            insert into elements(type, bytes, boardId, timestamp, sequence, zOrder, author, layerId, groupId)
+            values($1, $2, $3, $4, $5, $6, $7, nullif($8, 0), nullif($9, 0)) returning id
+        `, element.Type, element.Bytes, target, element.Timestamp, sequence, element.ZOrder, author, layers[element.Layer], group).Scan(&id) != nil { return false }
+
+        if impl.recordOperation(tx, &Operation{
+            Board: target,
+            Sequence: sequence,
+            Username: author,
+            Kind: OperationAdd,
+            ElementId: id,
+            ElementType: element.Type,
+            After: element.Bytes,
+            ZOrderAfter: element.ZOrder,
+            State: OperationDiscarded,
+        }) < 0 { return false }
+    }
+
+    return true
+}
+
+func (impl *DatabaseImpl) ForkBoard(username []byte, id int32, timestamp uint64) *Board { // nillable
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    board := &Board{}
+    if tx.QueryRow(
+        "select b.id, b.color, b.title from boards b inner join userAndBoard uab on b.id = uab.boardId where uab.username = $1 and b.id = $2 and b.deletedAt is null for share",
+        username, id,
+    ).Scan(&(board.Id), &(board.Color), &(board.Title)) != nil { return nil }
+
+    var elements []*Element
+    if timestamp == 0 {
+        elements = impl.scanElements(tx.Query("select " + elementColumns + " from elements where boardId = $1 and removedAt is null", id))
+    } else {
+        elements = impl.elementsAt(tx, id, timestamp)
+        if elements == nil { return nil }
+
+        // the history doesn't track layers and groups, so the elements' current ones are taken
+        current := impl.scanElements(tx.Query("select " + elementColumns + " from elements where boardId = $1", id))
+        if current == nil { return nil }
+
+        placements := make(map[int32]*Element)
+        for _, element := range current { placements[element.Id] = element }
+
+        for _, element := range elements {
+            if placement, exists := placements[element.Id]; exists { element.Layer, element.Group = placement.Layer, placement.Group }
+        }
+    }
+    if elements == nil { return nil }
+
+    board.Id = impl.insertBoard(tx, username, board)
+    if board.Id < 0 { return nil }
+
+    if !impl.copyBoardContents(tx, username, id, board.Id, elements) { return nil }
+
+    if tx.Commit() != nil { return nil }
+    return board
+}
@@ -0,0 +1,447 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+    "JaonedServer/utils"
+    "database/sql"
+    "reflect"
+    "sort"
+)
+
+type OperationKind int32
+
+const (
+    OperationAdd OperationKind = 0
+    OperationUpdate OperationKind = 1
+    OperationRemove OperationKind = 2
+    OperationClear OperationKind = 3 // its removals are recorded as child operations
+    OperationUndo OperationKind = 4
+    OperationRedo OperationKind = 5
+    OperationReorder OperationKind = 6
+    OperationBatch OperationKind = 7 // changes several elements at once, they're recorded as child operations
+)
+
+type OperationState int32
+
+const (
+    OperationDone OperationState = 0
+    OperationUndone OperationState = 1
+    OperationDiscarded OperationState = 2 // undone and then superseded by a newer operation of the same user, can't be redone
+)
+
+type Operation struct {
+    Id int32
+    Board int32
+    Sequence int64
+    Username []byte
+    Kind OperationKind
+    Parent int32 // zero if none
+    Target int32 // the undone or redone operation, zero if none
+    ElementId int32
+    ElementType ElementType
+    Before []byte // nillable
+    After []byte // nillable
+    ZOrderBefore int64
+    ZOrderAfter int64
+    State OperationState
+    Timestamp uint64
+}
+
+const operationColumns = "id, boardId, sequence, username, kind, parentId, targetId, elementId, elementType, before, after, zOrderBefore, zOrderAfter, state, timestamp"
+
+func scanOperation(row interface{ Scan(dest ...any) error }) *Operation { // nillable
+    operation := &Operation{}
+
+    if row.Scan(
+        &(operation.Id),
+        &(operation.Board),
+        &(operation.Sequence),
+        &(operation.Username),
+        &(operation.Kind),
+        &(operation.Parent),
+        &(operation.Target),
+        &(operation.ElementId),
+        &(operation.ElementType),
+        &(operation.Before),
+        &(operation.After),
+        &(operation.ZOrderBefore),
+        &(operation.ZOrderAfter),
+        &(operation.State),
+        &(operation.Timestamp),
+    ) != nil { return nil }
+
+    return operation
+}
+
+func (impl *DatabaseImpl) recordOperation(tx *sql.Tx, operation *Operation) int32 { // negative on failure
+    operation.Timestamp = utils.CurrentTimeMillis()
+
+    if operation.Parent == 0 && operation.Kind != OperationUndo && operation.Kind != OperationRedo {
+        _, err := tx.Exec(
+            "update operations set state = $1 where boardId = $2 and username = $3 and state = $4",
+            OperationDiscarded, operation.Board, operation.Username, OperationUndone,
+        )
+        if err != nil { return -1 }
+    }
+
+    row := tx.QueryRow(`
+        insert into operations(boardId, sequence, username, kind, parentId, targetId, elementId, elementType, before, after, zOrderBefore, zOrderAfter, state, timestamp)
+        values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) returning id
+    `,
+        operation.Board,
+        operation.Sequence,
+        operation.Username,
+        operation.Kind,
+        operation.Parent,
+        operation.Target,
+        operation.ElementId,
+        operation.ElementType,
+        operation.Before,
+        operation.After,
+        operation.ZOrderBefore,
+        operation.ZOrderAfter,
+        operation.State,
+        operation.Timestamp,
+    )
+
+    if row.Scan(&(operation.Id)) != nil { return -1 }
+    return operation.Id
+}
+
+func (impl *DatabaseImpl) findUndoable(tx *sql.Tx, username []byte, board int32, state OperationState) *Operation { // nillable
+    var order string
+    if state == OperationDone {
+        order = "desc" // undo goes backwards
+    } else {
+        order = "asc" // while redo goes forwards through what was undone
+    }
+
+    return scanOperation(tx.QueryRow(
+        "select " + operationColumns + " from operations where boardId = $1 and username = $2 and parentId = 0 and kind in ($3, $4, $5, $6, $7, $8) and state = $9 order by id " + order + " limit 1 for update",
+        board, username, OperationAdd, OperationUpdate, OperationRemove, OperationClear, OperationReorder, OperationBatch, state,
+    ))
+}
+
+func (impl *DatabaseImpl) childOperations(tx *sql.Tx, parent int32) []*Operation { // nillable
+    rows, err := tx.Query("select " + operationColumns + " from operations where parentId = $1 order by id", parent)
+    if err != nil { return nil }
+
+    operations := make([]*Operation, 0)
+
+    for rows.Next() {
+        operation := scanOperation(rows)
+        if operation == nil { return nil }
+        operations = append(operations, operation)
+    }
+
+    return operations
+}
+
+// what undo and redo work on, the board itself or its in-memory replay
+type elementStore interface {
+    current(id int32) *Element // nillable - removed or never added
+    restore(operation *Operation, bytes []byte, zOrder int64) *Element // nillable
+    update(id int32, bytes []byte) *Element // nillable
+    reorder(id int32, zOrder int64) *Element // nillable
+    remove(id int32) *Tombstone // nillable
+    children(parent int32) []*Operation // nillable
+}
+
+type boardStore struct {
+    impl *DatabaseImpl
+    tx *sql.Tx
+    username []byte
+    board int32
+    sequence int64
+}
+
+func (store *boardStore) current(id int32) *Element { // nillable
+    return scanElement(store.tx.QueryRow("select " + elementColumns + " from elements where boardId = $1 and id = $2 and removedAt is null for update", store.board, id))
+}
+
+func (store *boardStore) restore(operation *Operation, bytes []byte, zOrder int64) *Element { // nillable
+    return store.impl.restoreElementRow(store.tx, store.board, &Element{Id: operation.ElementId, Sequence: store.sequence, ZOrder: zOrder, Type: operation.ElementType, Bytes: bytes})
+}
+
+func (store *boardStore) update(id int32, bytes []byte) *Element { // nillable
+    return store.impl.updateElementRow(store.tx, store.board, id, bytes, store.sequence)
+}
+
+func (store *boardStore) reorder(id int32, zOrder int64) *Element { // nillable
+    return store.impl.reorderElementRow(store.tx, store.board, id, zOrder, store.sequence)
+}
+
+func (store *boardStore) remove(id int32) *Tombstone { // nillable
+    if store.impl.removeElementRow(store.tx, store.username, store.board, id, store.sequence) == nil { return nil }
+    return &Tombstone{id, store.sequence}
+}
+
+func (store *boardStore) children(parent int32) []*Operation { // nillable
+    return store.impl.childOperations(store.tx, parent)
+}
+
+// redoes the operation if forwards, undoes it otherwise, elements that someone else has changed in the meantime
+// (so they no longer look the way the operation left or found them) are skipped and left as they are
+func playOperation(store elementStore, operation *Operation, forwards bool, changes *Changes) bool {
+    switch operation.Kind {
+        case OperationAdd, OperationRemove:
+            var bytes []byte // how the element looks while it's on the board
+            var zOrder int64
+            if operation.Kind == OperationAdd {
+                bytes, zOrder = operation.After, operation.ZOrderAfter
+            } else {
+                bytes, zOrder = operation.Before, operation.ZOrderBefore
+            }
+
+            current := store.current(operation.ElementId)
+
+            if (operation.Kind == OperationAdd) == forwards {
+                if current != nil { return true }
+
+                element := store.restore(operation, bytes, zOrder)
+                if element == nil { return false }
+                changes.Elements = append(changes.Elements, element)
+            } else if current != nil && reflect.DeepEqual(current.Bytes, bytes) && current.ZOrder == zOrder {
+                if tombstone := store.remove(operation.ElementId); tombstone != nil { changes.Tombstones = append(changes.Tombstones, tombstone) }
+            }
+        case OperationUpdate:
+            from, to := operation.Before, operation.After
+            if !forwards { from, to = to, from }
+
+            if current := store.current(operation.ElementId); current != nil && reflect.DeepEqual(current.Bytes, from) {
+                if element := store.update(operation.ElementId, to); element != nil { changes.Elements = append(changes.Elements, element) }
+            }
+        case OperationReorder:
+            from, to := operation.ZOrderBefore, operation.ZOrderAfter
+            if !forwards { from, to = to, from }
+
+            if current := store.current(operation.ElementId); current != nil && current.ZOrder == from {
+                if element := store.reorder(operation.ElementId, to); element != nil { changes.Elements = append(changes.Elements, element) }
+            }
+        case OperationClear, OperationBatch:
+            children := store.children(operation.Id)
+            if children == nil { return false }
+
+            if forwards {
+                for _, child := range children {
+                    if !playOperation(store, child, true, changes) { return false }
+                }
+            } else {
+                for index := len(children) - 1; index >= 0; index-- {
+                    if !playOperation(store, children[index], false, changes) { return false }
+                }
+            }
+    }
+    return true
+}
+
+func (impl *DatabaseImpl) undoOrRedo(username []byte, board int32, kind OperationKind) *Changes { // nillable
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    var state, newState OperationState
+    if kind == OperationUndo {
+        state, newState = OperationDone, OperationUndone
+    } else {
+        state, newState = OperationUndone, OperationDone
+    }
+
+    target := impl.findUndoable(tx, username, board, state)
+    if target == nil { return nil }
+
+    changes := &Changes{false, impl.nextSequence(tx, board), make([]*Element, 0), make([]*Tombstone, 0)}
+    if changes.Sequence < 0 { return nil }
+
+    store := &boardStore{impl, tx, username, board, changes.Sequence}
+    if !playOperation(store, target, kind == OperationRedo, changes) { return nil }
+
+    _, err = tx.Exec("update operations set state = $1 where id = $2", newState, target.Id)
+    if err != nil { return nil }
+
+    if impl.recordOperation(tx, &Operation{
+        Board: board,
+        Sequence: changes.Sequence,
+        Username: username,
+        Kind: kind,
+        Target: target.Id,
+    }) < 0 { return nil }
+
+    if tx.Commit() != nil { return nil }
+    return changes
+}
+
+func (impl *DatabaseImpl) Undo(username []byte, board int32) *Changes { // nillable
+    return impl.undoOrRedo(username, board, OperationUndo)
+}
+
+func (impl *DatabaseImpl) Redo(username []byte, board int32) *Changes { // nillable
+    return impl.undoOrRedo(username, board, OperationRedo)
+}
+
+type replayedElement struct {
+    element *Element
+    removed bool
+}
+
+type replay struct {
+    elements map[int32]*replayedElement
+    childOperations map[int32][]*Operation
+    operations map[int32]*Operation
+}
+
+func newReplay() *replay {
+    return &replay{make(map[int32]*replayedElement), make(map[int32][]*Operation), make(map[int32]*Operation)}
+}
+
+func (replay *replay) current(id int32) *Element { // nillable
+    if replayed, exists := replay.elements[id]; exists && !replayed.removed { return replayed.element }
+    return nil
+}
+
+func (replay *replay) restore(operation *Operation, bytes []byte, zOrder int64) *Element {
+    element := &Element{Id: operation.ElementId, ZOrder: zOrder, Type: operation.ElementType, Bytes: bytes}
+    if replayed, exists := replay.elements[operation.ElementId]; exists {
+        element.Author, element.Timestamp = replayed.element.Author, replayed.element.Timestamp
+    } else {
+        element.Author, element.Timestamp = operation.Username, operation.Timestamp
+    }
+
+    replay.elements[operation.ElementId] = &replayedElement{element, false}
+    return element
+}
+
+func (replay *replay) update(id int32, bytes []byte) *Element { // nillable
+    element := replay.current(id)
+    if element != nil { element.Bytes = bytes }
+    return element
+}
+
+func (replay *replay) reorder(id int32, zOrder int64) *Element { // nillable
+    element := replay.current(id)
+    if element != nil { element.ZOrder = zOrder }
+    return element
+}
+
+func (replay *replay) remove(id int32) *Tombstone { // nillable
+    if replay.current(id) == nil { return nil }
+
+    replay.elements[id].removed = true
+    return &Tombstone{id, 0}
+}
+
+func (replay *replay) children(parent int32) []*Operation {
+    if children, exists := replay.childOperations[parent]; exists { return children }
+    return make([]*Operation, 0)
+}
+
+// repeats the operation the way it was originally done, undo and redo go through the same checks as they did on the board
+func (replay *replay) play(operation *Operation) {
+    switch operation.Kind {
+        case OperationAdd:
+            replay.restore(operation, operation.After, operation.ZOrderAfter)
+        case OperationRemove:
+            replay.remove(operation.ElementId)
+        case OperationUpdate:
+            replay.update(operation.ElementId, operation.After)
+        case OperationReorder:
+            replay.reorder(operation.ElementId, operation.ZOrderAfter)
+        case OperationClear, OperationBatch:
+            for _, child := range replay.children(operation.Id) { replay.play(child) }
+        case OperationUndo, OperationRedo:
+            if target, exists := replay.operations[operation.Target]; exists {
+                playOperation(replay, target, operation.Kind == OperationRedo, &Changes{false, 0, make([]*Element, 0), make([]*Tombstone, 0)})
+            }
+    }
+}
+
+type querier interface {
+    Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// child operations count as done when their parent was, so none of a clear is replayed partially, the log is streamed and
+// only the operations that get undone or redone later are kept, along with their children
+func (impl *DatabaseImpl) elementsAt(querier querier, board int32, timestamp uint64) []*Element { // nillable
+    rows, err := querier.Query(
+        "select distinct targetId from operations where boardId = $1 and kind in ($2, $3) and timestamp <= $4",
+        board, OperationUndo, OperationRedo, timestamp,
+    )
+    if err != nil { return nil }
+
+    targets := make(map[int32]bool)
+
+    for rows.Next() {
+        var target int32
+        if rows.Scan(&target) != nil { return nil }
+        targets[target] = true
+    }
+
+    rows, err = querier.Query(`
+        select ` + operationColumns + ` from (
+            select o.*, coalesce(p.timestamp, o.timestamp) as doneAt, coalesce(p.id, o.id) as rootId from operations o
+            left join operations p on p.id = o.parentId
+            where o.boardId = $1
+        ) o where doneAt <= $2 and ` + liveBoard + ` order by doneAt, rootId, parentId <> 0, id
+    `, board, timestamp)
+    if err != nil { return nil }
+
+    replay := newReplay()
+    var pending *Operation = nil // the latest top-level operation, played once all of its children have arrived
+
+    settle := func() {
+        if pending == nil { return }
+
+        replay.play(pending)
+        if !targets[pending.Id] { delete(replay.childOperations, pending.Id) }
+    }
+
+    for rows.Next() {
+        operation := scanOperation(rows)
+        if operation == nil { return nil }
+
+        if operation.Parent != 0 {
+            replay.childOperations[operation.Parent] = append(replay.childOperations[operation.Parent], operation)
+            continue
+        }
+
+        settle()
+
+        pending = operation
+        if targets[operation.Id] { replay.operations[operation.Id] = operation }
+    }
+
+    settle()
+
+    elements := make([]*Element, 0)
+    for _, replayed := range replay.elements {
+        if !replayed.removed { elements = append(elements, replayed.element) }
+    }
+
+    sort.Slice(elements, func(i, j int) bool {
+        if elements[i].ZOrder != elements[j].ZOrder { return elements[i].ZOrder < elements[j].ZOrder }
+        return elements[i].Id < elements[j].Id
+    })
+
+    return elements
+}
+
+func (impl *DatabaseImpl) GetElementsAt(board int32, timestamp uint64) []*Element { // nillable
+    return impl.elementsAt(impl.db, board, timestamp)
+}
@@ -0,0 +1,175 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+    "reflect"
+    "testing"
+)
+
+var alice = []byte("alice")
+var bob = []byte("bob")
+
+func added(id int32, element int32, username []byte, bytes string, zOrder int64) *Operation {
+    return &Operation{Id: id, Username: username, Kind: OperationAdd, ElementId: element, After: []byte(bytes), ZOrderAfter: zOrder}
+}
+
+func updated(id int32, element int32, username []byte, before string, after string) *Operation {
+    return &Operation{Id: id, Username: username, Kind: OperationUpdate, ElementId: element, Before: []byte(before), After: []byte(after)}
+}
+
+func removed(id int32, parent int32, element int32, username []byte, bytes string, zOrder int64) *Operation {
+    return &Operation{Id: id, Username: username, Kind: OperationRemove, Parent: parent, ElementId: element, Before: []byte(bytes), ZOrderBefore: zOrder}
+}
+
+func reordered(id int32, element int32, username []byte, before int64, after int64) *Operation {
+    return &Operation{Id: id, Username: username, Kind: OperationReorder, ElementId: element, ZOrderBefore: before, ZOrderAfter: after}
+}
+
+func undone(id int32, username []byte, target int32) *Operation {
+    return &Operation{Id: id, Username: username, Kind: OperationUndo, Target: target}
+}
+
+func replayOf(operations []*Operation) *replay {
+    replay := newReplay()
+
+    for _, operation := range operations {
+        replay.operations[operation.Id] = operation
+        if operation.Parent != 0 { replay.childOperations[operation.Parent] = append(replay.childOperations[operation.Parent], operation) }
+    }
+
+    for _, operation := range operations {
+        if operation.Parent == 0 { replay.play(operation) }
+    }
+
+    return replay
+}
+
+func TestPlayOperation(t *testing.T) {
+    tests := []struct {
+        name string
+        history []*Operation
+        target int32
+        forwards bool
+        elements map[int32]string // bytes of the elements left on the board
+        changed int
+        removed int
+    }{
+        {
+            name: "undo of an add",
+            history: []*Operation{added(1, 10, alice, "a", 1)},
+            target: 1,
+            elements: map[int32]string{},
+            removed: 1,
+        },
+        {
+            name: "undo of an add after another user's edit",
+            history: []*Operation{added(1, 10, alice, "a", 1), updated(2, 10, bob, "a", "b")},
+            target: 1,
+            elements: map[int32]string{10: "b"},
+        },
+        {
+            name: "undo of an update",
+            history: []*Operation{added(1, 10, alice, "a", 1), updated(2, 10, alice, "a", "b")},
+            target: 2,
+            elements: map[int32]string{10: "a"},
+            changed: 1,
+        },
+        {
+            name: "undo of an update after another user's edit",
+            history: []*Operation{added(1, 10, alice, "a", 1), updated(2, 10, alice, "a", "b"), updated(3, 10, bob, "b", "c")},
+            target: 2,
+            elements: map[int32]string{10: "c"},
+        },
+        {
+            name: "undo of a reorder after another user's reorder",
+            history: []*Operation{added(1, 10, alice, "a", 1), reordered(2, 10, alice, 1, 5), reordered(3, 10, bob, 5, 0)},
+            target: 2,
+            elements: map[int32]string{10: "a"},
+        },
+        {
+            name: "undo of a removal",
+            history: []*Operation{added(1, 10, bob, "a", 1), removed(2, 0, 10, alice, "a", 1)},
+            target: 2,
+            elements: map[int32]string{10: "a"},
+            changed: 1,
+        },
+        {
+            name: "undo of a clear",
+            history: []*Operation{
+                added(1, 10, alice, "a", 1),
+                added(2, 11, bob, "b", 2),
+                {Id: 3, Username: alice, Kind: OperationClear},
+                removed(4, 3, 10, alice, "a", 1),
+                removed(5, 3, 11, alice, "b", 2),
+            },
+            target: 3,
+            elements: map[int32]string{10: "a", 11: "b"},
+            changed: 2,
+        },
+        {
+            name: "redo of an update",
+            history: []*Operation{added(1, 10, alice, "a", 1), updated(2, 10, alice, "a", "b"), undone(3, alice, 2)},
+            target: 2,
+            forwards: true,
+            elements: map[int32]string{10: "b"},
+            changed: 1,
+        },
+        {
+            name: "redo of an update after another user's new action",
+            history: []*Operation{added(1, 10, alice, "a", 1), updated(2, 10, alice, "a", "b"), undone(3, alice, 2), updated(4, 10, bob, "a", "c")},
+            target: 2,
+            forwards: true,
+            elements: map[int32]string{10: "c"},
+        },
+        {
+            name: "redo of a removal after another user's new action",
+            history: []*Operation{added(1, 10, bob, "a", 1), removed(2, 0, 10, alice, "a", 1), undone(3, alice, 2), updated(4, 10, bob, "a", "c")},
+            target: 2,
+            forwards: true,
+            elements: map[int32]string{10: "c"},
+        },
+        {
+            name: "redo of an undone add",
+            history: []*Operation{added(1, 10, alice, "a", 1), undone(2, alice, 1)},
+            target: 1,
+            forwards: true,
+            elements: map[int32]string{10: "a"},
+            changed: 1,
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            replay := replayOf(test.history)
+            changes := &Changes{false, 0, make([]*Element, 0), make([]*Tombstone, 0)}
+
+            if !playOperation(replay, replay.operations[test.target], test.forwards, changes) { t.Fatal("failed") }
+
+            elements := make(map[int32]string)
+            for id := range replay.elements {
+                if element := replay.current(id); element != nil { elements[id] = string(element.Bytes) }
+            }
+
+            if !reflect.DeepEqual(elements, test.elements) { t.Errorf("elements %v, expected %v", elements, test.elements) }
+            if len(changes.Elements) != test.changed { t.Errorf("%d elements changed, expected %d", len(changes.Elements), test.changed) }
+            if len(changes.Tombstones) != test.removed { t.Errorf("%d elements removed, expected %d", len(changes.Tombstones), test.removed) }
+        })
+    }
+}
@@ -0,0 +1,273 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+    "database/sql"
+    "github.com/lib/pq"
+)
+
+func (impl *DatabaseImpl) AddLayer(board int32, layer *Layer) *Layer { // nillable
+    if len(layer.Name) > MaxLayerNameSize { return nil }
+
+    row := impl.db.QueryRow(
+        "insert into layers(boardId, name, visible, locked, layerOrder) select id, $2, $3, $4, $5 from boards where id = $1 and not locked and deletedAt is null returning id",
+        board, layer.Name, layer.Visible, layer.Locked, layer.Order,
+    )
+
+    added := *layer
+    if row.Scan(&(added.Id)) != nil { return nil }
+
+    return &added
+}
+
+func (impl *DatabaseImpl) UpdateLayer(board int32, layer *Layer) bool {
+    if len(layer.Name) > MaxLayerNameSize { return false }
+
+    result, err := impl.db.Exec(
+        "update layers set name = $1, visible = $2, locked = $3, layerOrder = $4 where boardId = $5 and id = $6 and not exists(select 1 from boards where boards.id = $5 and (boards.locked or boards.deletedAt is not null))",
+        layer.Name, layer.Visible, layer.Locked, layer.Order, board, layer.Id,
+    )
+    if err != nil { return false }
+
+    affected, err := result.RowsAffected()
+    return err == nil && affected > 0
+}
+
+func (impl *DatabaseImpl) GetLayers(board int32) []*Layer { // nillable
+    rows, err := impl.db.Query("select id, name, visible, locked, layerOrder from layers where boardId = $1 and " + liveBoard + " order by layerOrder, id", board)
+    if err != nil { return nil }
+
+    layers := make([]*Layer, 0)
+
+    for rows.Next() {
+        layer := &Layer{}
+        if rows.Scan(&(layer.Id), &(layer.Name), &(layer.Visible), &(layer.Locked), &(layer.Order)) != nil { return nil }
+        layers = append(layers, layer)
+    }
+
+    return layers
+}
+
+func (impl *DatabaseImpl) RemoveLayer(board int32, id int32) bool { // its elements stay on the board, just without a layer
+    tx, err := impl.db.Begin()
+    if err != nil { return false }
+    defer tx.Rollback()
+
+    sequence := impl.nextSequence(tx, board)
+    if sequence < 0 { return false }
+
+    _, err = tx.Exec("update elements set layerId = null, sequence = $1 where boardId = $2 and layerId = $3", sequence, board, id)
+    if err != nil { return false }
+
+    result, err := tx.Exec("delete from layers where boardId = $1 and id = $2", board, id)
+    if err != nil { return false }
+
+    if affected, err := result.RowsAffected(); err != nil || affected == 0 { return false }
+    return tx.Commit() == nil
+}
+
+func (impl *DatabaseImpl) MoveElementToLayer(board int32, id int32, layer int32) *Element { // nillable
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    sequence := impl.nextSequence(tx, board)
+    if sequence < 0 { return nil }
+
+    if layer != 0 {
+        var locked bool
+        if tx.QueryRow("select locked from layers where boardId = $1 and id = $2", board, layer).Scan(&locked) != nil || locked { return nil }
+    }
+
+    element := scanElement(tx.QueryRow(
+        "update elements set layerId = nullif($1, 0), sequence = $2 where boardId = $3 and id = $4 and removedAt is null and " + unlockedElement + " returning " + elementColumns,
+        layer, sequence, board, id,
+    ))
+    if element == nil { return nil }
+
+    if tx.Commit() != nil { return nil }
+    return element
+}
+
+func (impl *DatabaseImpl) GetLayerElements(board int32, layer int32) []*Element { // nillable
+    return impl.scanElements(impl.db.Query(
+        "select " + elementColumns + " from elements where boardId = $1 and coalesce(layerId, 0) = $2 and removedAt is null and " + liveBoard + " order by zOrder, id",
+        board, layer,
+    ))
+}
+
+func (impl *DatabaseImpl) scanElements(rows *sql.Rows, err error) []*Element { // nillable
+    if err != nil { return nil }
+
+    elements := make([]*Element, 0)
+
+    for rows.Next() {
+        element := scanElement(rows)
+        if element == nil { return nil }
+        elements = append(elements, element)
+    }
+
+    return elements
+}
+
+func (impl *DatabaseImpl) GroupElements(board int32, ids []int32) *Changes { // nillable - the elements carry the new group's id
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    changes := &Changes{false, impl.nextSequence(tx, board), nil, make([]*Tombstone, 0)}
+    if changes.Sequence < 0 { return nil }
+
+    var group int32
+    if tx.QueryRow("insert into elementGroups(boardId) values($1) returning id", board).Scan(&group) != nil { return nil }
+
+    changes.Elements = impl.scanElements(tx.Query(
+        "update elements set groupId = $1, sequence = $2 where boardId = $3 and id = any($4) and removedAt is null and " + unlockedElement + " returning " + elementColumns,
+        group, changes.Sequence, board, pq.Array(ids),
+    ))
+    if len(changes.Elements) == 0 { return nil }
+
+    if tx.Commit() != nil { return nil }
+    return changes
+}
+
+func (impl *DatabaseImpl) Ungroup(board int32, group int32) *Changes { // nillable
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    changes := &Changes{false, impl.nextSequence(tx, board), nil, make([]*Tombstone, 0)}
+    if changes.Sequence < 0 { return nil }
+
+    changes.Elements = impl.scanElements(tx.Query(
+        "update elements set groupId = null, sequence = $1 where boardId = $2 and groupId = $3 returning " + elementColumns,
+        changes.Sequence, board, group,
+    ))
+    if changes.Elements == nil { return nil }
+
+    result, err := tx.Exec("delete from elementGroups where boardId = $1 and id = $2", board, group)
+    if err != nil { return nil }
+    if affected, err := result.RowsAffected(); err != nil || affected == 0 { return nil }
+
+    if tx.Commit() != nil { return nil }
+    return changes
+}
+
+// nillable - also if any of them is on a locked layer, so a group is changed either as a whole or not at all
+func (impl *DatabaseImpl) groupMembers(tx *sql.Tx, board int32, group int32) []*Element {
+    var locked bool
+    if tx.QueryRow(
+        "select exists(select 1 from elements where boardId = $1 and groupId = $2 and removedAt is null and not " + unlockedElement + ")",
+        board, group,
+    ).Scan(&locked) != nil || locked { return nil }
+
+    return impl.scanElements(tx.Query(
+        "select " + elementColumns + " from elements where boardId = $1 and groupId = $2 and removedAt is null order by zOrder, id for update",
+        board, group,
+    ))
+}
+
+func (impl *DatabaseImpl) MoveGroup(username []byte, board int32, group int32, dx float32, dy float32) *Changes { // nillable
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    changes := &Changes{false, impl.nextSequence(tx, board), make([]*Element, 0), make([]*Tombstone, 0)}
+    if changes.Sequence < 0 { return nil }
+
+    members := impl.groupMembers(tx, board, group)
+    if len(members) == 0 { return nil }
+
+    parent := impl.recordOperation(tx, &Operation{
+        Board: board,
+        Sequence: changes.Sequence,
+        Username: username,
+        Kind: OperationBatch,
+    })
+    if parent < 0 { return nil }
+
+    for _, member := range members {
+        moved := TranslateElement(member.Type, member.Bytes, dx, dy)
+        if moved == nil { return nil }
+
+        element := impl.updateElementRow(tx, board, member.Id, moved, changes.Sequence)
+        if element == nil { return nil }
+
+        if impl.recordOperation(tx, &Operation{
+            Board: board,
+            Sequence: changes.Sequence,
+            Username: username,
+            Kind: OperationUpdate,
+            Parent: parent,
+            ElementId: member.Id,
+            ElementType: member.Type,
+            Before: member.Bytes,
+            After: moved,
+            ZOrderBefore: member.ZOrder,
+            ZOrderAfter: member.ZOrder,
+        }) < 0 { return nil }
+
+        changes.Elements = append(changes.Elements, element)
+    }
+
+    if tx.Commit() != nil { return nil }
+    return changes
+}
+
+func (impl *DatabaseImpl) RemoveGroup(username []byte, board int32, group int32) *Changes { // nillable
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    changes := &Changes{false, impl.nextSequence(tx, board), make([]*Element, 0), make([]*Tombstone, 0)}
+    if changes.Sequence < 0 { return nil }
+
+    members := impl.groupMembers(tx, board, group)
+    if len(members) == 0 { return nil }
+
+    parent := impl.recordOperation(tx, &Operation{
+        Board: board,
+        Sequence: changes.Sequence,
+        Username: username,
+        Kind: OperationBatch,
+    })
+    if parent < 0 { return nil }
+
+    for _, member := range members {
+        if impl.removeElementRow(tx, username, board, member.Id, changes.Sequence) == nil { return nil }
+
+        if impl.recordOperation(tx, &Operation{
+            Board: board,
+            Sequence: changes.Sequence,
+            Username: username,
+            Kind: OperationRemove,
+            Parent: parent,
+            ElementId: member.Id,
+            ElementType: member.Type,
+            Before: member.Bytes,
+            ZOrderBefore: member.ZOrder,
+        }) < 0 { return nil }
+
+        changes.Tombstones = append(changes.Tombstones, &Tombstone{member.Id, changes.Sequence})
+    }
+
+    if tx.Commit() != nil { return nil }
+    return changes
+}
@@ -0,0 +1,74 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+const availableTemplate = "b.deletedAt is null and (b.template = $1 or (b.template = $2 and exists(select 1 from userAndBoard uab where uab.boardId = b.id and uab.username = $3)))"
+
+// only admins can make a template global or change a global one, members of the board can manage personal ones
+func (impl *DatabaseImpl) SetBoardTemplate(username []byte, admin bool, id int32, scope TemplateScope) bool {
+    if scope < TemplateNone || scope > TemplateGlobal { return false }
+
+    result, err := impl.db.Exec(`
+        update boards b set template = $1 where b.id = $2 and ($3 or (
+            b.template <> $4 and $1 <> $4 and exists(select 1 from userAndBoard uab where uab.boardId = b.id and uab.username = $5)
+        ))
+    `, scope, id, admin, TemplateGlobal, username)
+    if err != nil { return false }
+
+    affected, err := result.RowsAffected()
+    return err == nil && affected > 0
+}
+
+func (impl *DatabaseImpl) GetTemplates(username []byte) []*Board { // nillable
+    rows, err := impl.db.Query("select b.id, b.color, b.title from boards b where " + availableTemplate + " order by b.id", TemplateGlobal, TemplatePersonal, username)
+    if err != nil { return nil }
+
+    boards := make([]*Board, 0)
+
+    for rows.Next() {
+        board := &Board{}
+        if rows.Scan(&(board.Id), &(board.Color), &(board.Title)) != nil { return nil }
+        boards = append(boards, board)
+    }
+
+    return boards
+}
+
+func (impl *DatabaseImpl) InstantiateTemplate(username []byte, id int32) *Board { // nillable
+    tx, err := impl.db.Begin()
+    if err != nil { return nil }
+    defer tx.Rollback()
+
+    board := &Board{}
+    if tx.QueryRow(
+        "select b.id, b.color, b.title from boards b where b.id = $4 and " + availableTemplate + " for share",
+        TemplateGlobal, TemplatePersonal, username, id,
+    ).Scan(&(board.Id), &(board.Color), &(board.Title)) != nil { return nil }
+
+    elements := impl.scanElements(tx.Query("select " + elementColumns + " from elements where boardId = $1 and removedAt is null", id))
+    if elements == nil { return nil }
+
+    board.Id = impl.insertBoard(tx, username, board)
+    if board.Id < 0 { return nil }
+
+    if !impl.copyBoardContents(tx, username, id, board.Id, elements) { return nil }
+
+    if tx.Commit() != nil { return nil }
+    return board
+}
@@ -34,6 +34,8 @@ type Clients interface {
     dequeueMessageFromClient(connection net.Conn, timestamp int64) *Message // nillable
     selectBoard(connection net.Conn, board int32)
     getBoard(connection net.Conn) int32 // might be negative
+    boardViewers(board int32, except net.Conn) []net.Conn
+    usersConnections(usernames [][]byte, except net.Conn) []net.Conn
 }
 
 type ClientsImpl struct {
@@ -45,6 +47,7 @@ type Client struct {
     *database.User
     pendingMessages map[int64][]*Message
     board int32
+    session []byte // nillable
 }
 
 var clientsInitialized = false
@@ -114,3 +117,30 @@ func (impl *ClientsImpl) selectBoard(connection net.Conn, board int32) {
 func (impl *ClientsImpl) getBoard(connection net.Conn) int32 { // might be negative
     return impl.clients[connection].board
 }
+
+func (impl *ClientsImpl) boardViewers(board int32, except net.Conn) []net.Conn {
+    impl.rwMutex.RLock()
+
+    connections := make([]net.Conn, 0)
+    for connection, client := range impl.clients {
+        if connection != except && client.board == board { connections = append(connections, connection) }
+    }
+
+    impl.rwMutex.RUnlock()
+    return connections
+}
+
+func (impl *ClientsImpl) usersConnections(usernames [][]byte, except net.Conn) []net.Conn {
+    wanted := make(map[string]bool)
+    for _, username := range usernames { wanted[string(username)] = true }
+
+    impl.rwMutex.RLock()
+
+    connections := make([]net.Conn, 0)
+    for connection, client := range impl.clients {
+        if connection != except && wanted[string(client.Username)] { connections = append(connections, connection) }
+    }
+
+    impl.rwMutex.RUnlock()
+    return connections
+}
@@ -0,0 +1,221 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+    "JaonedServer/utils"
+    "math"
+    "net"
+    "sync"
+)
+
+type limitCategory int8
+
+const (
+    limitCategoryAuth limitCategory = 0
+    limitCategoryWrites limitCategory = 1
+    limitCategoryReads limitCategory = 2
+    limitCategories = 3
+)
+
+type bucketLimit struct {
+    capacity float64
+    refillPerSecond float64
+}
+
+var limitCategoryNames = [limitCategories]string{"AUTH", "WRITES", "READS"}
+
+// indexed by limitCategory, a multi-part message counts once
+var defaultConnectionLimits = [limitCategories]bucketLimit{
+    {5, 0.2}, // auth: 5 attempts, then one per 5 seconds
+    {100, 20}, // writes
+    {50, 10}, // reads
+}
+
+// indexed by limitCategory, applied across all connections of the same user
+var defaultUserLimits = [limitCategories]bucketLimit{
+    {10, 0.5},
+    {200, 40},
+    {100, 20},
+}
+
+// each throttled message spends one violation, when they run out the connection gets dropped
+var defaultViolationLimit = bucketLimit{50, 1}
+
+const userPruneIntervalMillis = 60 * 1000
+
+// reads JAONED_LIMIT_<NAME>_CAPACITY and JAONED_LIMIT_<NAME>_REFILL
+func limitSetting(name string, fallback bucketLimit) bucketLimit {
+    return bucketLimit{
+        utils.FloatSetting("JAONED_LIMIT_" + name + "_CAPACITY", fallback.capacity),
+        utils.FloatSetting("JAONED_LIMIT_" + name + "_REFILL", fallback.refillPerSecond),
+    }
+}
+
+type Limiter interface {
+    allow(connection net.Conn, username []byte, message *Message) utils.Triple // positive - allowed, neutral - throttled, negative - disconnect
+    forget(connection net.Conn)
+    pruneUsers(now uint64)
+}
+
+type LimiterImpl struct {
+    connectionLimits [limitCategories]bucketLimit
+    userLimits [limitCategories]bucketLimit
+    violationLimit bucketLimit
+    connections map[net.Conn]*connectionBuckets
+    users map[string]*[limitCategories]bucket
+    pruned uint64
+    mutex sync.Mutex
+}
+
+type connectionBuckets struct {
+    categories [limitCategories]bucket
+    violations bucket
+    upload int64 // timestamp of the latest multi-part message, its parts share the fate of the first one
+    uploadAllowed bool
+}
+
+type bucket struct {
+    tokens float64
+    updated uint64
+}
+
+var limiterInitialized = false
+
+func createLimiter() Limiter {
+    utils.Assert(!limiterInitialized)
+    limiterInitialized = true
+
+    impl := &LimiterImpl{
+        violationLimit: limitSetting("VIOLATIONS", defaultViolationLimit),
+        connections: make(map[net.Conn]*connectionBuckets),
+        users: make(map[string]*[limitCategories]bucket),
+        pruned: utils.CurrentTimeMillis(),
+    }
+
+    for index, name := range limitCategoryNames {
+        impl.connectionLimits[index] = limitSetting(name, defaultConnectionLimits[index])
+        impl.userLimits[index] = limitSetting("USER_" + name, defaultUserLimits[index])
+    }
+
+    return impl
+}
+
+func flagCategory(flag Flag) limitCategory {
+    switch flag {
+        case flagLogIn, flagRegister, flagResumeSession:
+            return limitCategoryAuth
+        case flagGetBoard, flagGetBoards, flagSelectBoard, flagGetBoardElements, flagGetBoardElementsSince,
+            flagGetLayers, flagGetLayerElements, flagGetBoardElementsAt, flagGetCheckpoints, flagGetCheckpointElements, flagGetTemplates, flagGetTrash, flagListBoards,
+            flagGetWorkspaces, flagGetFolders, flagGetFolderBoards:
+            return limitCategoryReads
+        default:
+            return limitCategoryWrites
+    }
+}
+
+func newBucket(limit bucketLimit, now uint64) bucket {
+    return bucket{limit.capacity, now}
+}
+
+func (xBucket *bucket) refill(limit bucketLimit, now uint64) {
+    if now <= xBucket.updated { return }
+
+    xBucket.tokens = math.Min(limit.capacity, xBucket.tokens + float64(now - xBucket.updated) / 1000 * limit.refillPerSecond)
+    xBucket.updated = now
+}
+
+func (xBucket *bucket) take(limit bucketLimit, now uint64) bool {
+    xBucket.refill(limit, now)
+
+    if xBucket.tokens < 1 { return false }
+
+    xBucket.tokens--
+    return true
+}
+
+func (impl *LimiterImpl) allow(connection net.Conn, username []byte, message *Message) utils.Triple {
+    impl.mutex.Lock()
+    defer impl.mutex.Unlock()
+
+    now := utils.CurrentTimeMillis()
+    if now - impl.pruned >= userPruneIntervalMillis { impl.pruneUsers(now) }
+
+    buckets := impl.connections[connection]
+    if buckets == nil {
+        buckets = &connectionBuckets{violations: newBucket(impl.violationLimit, now)}
+        for index := range buckets.categories { buckets.categories[index] = newBucket(impl.connectionLimits[index], now) }
+        impl.connections[connection] = buckets
+    }
+
+    if message.index > 0 {
+        if message.timestamp == buckets.upload {
+            if buckets.uploadAllowed { return utils.Positive }
+            return utils.Neutral // the rest of a throttled message is dropped too
+        }
+
+        if buckets.violations.take(impl.violationLimit, now) { return utils.Neutral } // a part of a message whose first part never came
+        return utils.Negative
+    }
+
+    category := flagCategory(message.flag)
+    allowed := buckets.categories[category].take(impl.connectionLimits[category], now)
+
+    if allowed && username != nil {
+        userBuckets := impl.users[string(username)]
+        if userBuckets == nil {
+            userBuckets = new([limitCategories]bucket)
+            for index := range userBuckets { userBuckets[index] = newBucket(impl.userLimits[index], now) }
+            impl.users[string(username)] = userBuckets
+        }
+
+        allowed = userBuckets[category].take(impl.userLimits[category], now)
+    }
+
+    if message.count > 1 {
+        buckets.upload = message.timestamp
+        buckets.uploadAllowed = allowed
+    }
+
+    if allowed { return utils.Positive }
+    if buckets.violations.take(impl.violationLimit, now) { return utils.Neutral }
+    return utils.Negative
+}
+
+func (impl *LimiterImpl) forget(connection net.Conn) {
+    impl.mutex.Lock()
+    delete(impl.connections, connection)
+    impl.mutex.Unlock()
+}
+
+// users whose buckets have refilled completely are indistinguishable from new ones, so they're dropped, expects the mutex to be locked
+func (impl *LimiterImpl) pruneUsers(now uint64) {
+    impl.pruned = now
+
+    for username, userBuckets := range impl.users {
+        full := true
+
+        for index := range userBuckets {
+            userBuckets[index].refill(impl.userLimits[index], now)
+            if userBuckets[index].tokens < impl.userLimits[index].capacity { full = false }
+        }
+
+        if full { delete(impl.users, username) }
+    }
+}
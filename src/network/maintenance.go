@@ -0,0 +1,97 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+    "JaonedServer/database"
+    "JaonedServer/utils"
+    "sync"
+    "time"
+)
+
+const (
+    maintenanceIntervalMillis = 60 * 60 * 1000
+    defaultTombstoneRetentionMillis = 7 * 24 * 60 * 60 * 1000 // removed elements are kept that long so the removal can be undone
+    defaultTrashRetentionMillis = 30 * 24 * 60 * 60 * 1000 // deleted boards are kept in the trash that long
+)
+
+type Maintenance interface {
+    start()
+    stop()
+    run()
+}
+
+type MaintenanceImpl struct {
+    db database.Database
+    tombstoneRetentionMillis uint64
+    trashRetentionMillis uint64
+    stopped chan struct{}
+    waitGroup sync.WaitGroup
+}
+
+var maintenanceInitialized = false
+
+func createMaintenance(db database.Database) Maintenance {
+    utils.Assert(!maintenanceInitialized)
+    maintenanceInitialized = true
+
+    tombstoneRetentionMillis := utils.IntSetting("JAONED_TOMBSTONE_RETENTION_MILLIS", defaultTombstoneRetentionMillis)
+    trashRetentionMillis := utils.IntSetting("JAONED_TRASH_RETENTION_MILLIS", defaultTrashRetentionMillis)
+    utils.Assert(tombstoneRetentionMillis >= 0 && trashRetentionMillis >= 0)
+
+    return &MaintenanceImpl{
+        db,
+        uint64(tombstoneRetentionMillis),
+        uint64(trashRetentionMillis),
+        make(chan struct{}),
+        sync.WaitGroup{},
+    }
+}
+
+func (impl *MaintenanceImpl) start() {
+    impl.waitGroup.Add(1)
+
+    go func() {
+        ticker := time.NewTicker(maintenanceIntervalMillis * time.Millisecond)
+        defer ticker.Stop()
+
+        for {
+            impl.run()
+
+            select {
+                case <-impl.stopped:
+                    impl.waitGroup.Done()
+                    return
+                case <-ticker.C:
+            }
+        }
+    }()
+}
+
+func (impl *MaintenanceImpl) stop() {
+    close(impl.stopped)
+    impl.waitGroup.Wait()
+}
+
+func (impl *MaintenanceImpl) run() {
+    now := utils.CurrentTimeMillis()
+
+    if !impl.db.PurgeTombstones(now - impl.tombstoneRetentionMillis) { println("unable to purge tombstones") }
+    if !impl.db.PurgeTrash(now - impl.trashRetentionMillis) { println("unable to purge trash") }
+}
@@ -31,6 +31,11 @@ import (
 
 type Network interface {
     ProcessClients()
+    admitClient(connection net.Conn) rejection
+    rejectClient(connection net.Conn, reason rejection)
+    releaseClient(connection net.Conn)
+    clientAuthenticated(connection net.Conn)
+    dropClient(connection net.Conn)
     processClient(connection net.Conn)
     receive(connection net.Conn, buffer []byte) utils.Triple
     receiveMessage(connection net.Conn) (*Message, error)
@@ -46,6 +51,11 @@ type NetworkImpl struct {
     receivingMessages atomic.Bool
     waitGroup sync.WaitGroup
     sync Sync
+    connectionCount int32
+    addressConnections map[string]int32
+    loginDeadlines map[net.Conn]uint64
+    dropped map[net.Conn]bool
+    connectionsMutex sync.Mutex
 }
 
 type Message struct {
@@ -63,6 +73,22 @@ const (
     maxMessageBodySize = maxMessageSize - messageHeadSize // 104
 )
 
+const (
+    maxConnections = 1024
+    maxConnectionsPerAddress = 16
+    loginDeadlineMillis = 10 * 1000 // unauthenticated connections get closed after that
+    idleTimeoutMillis = 15 * 60 * 1000
+    rejectionTimeoutMillis = 1000
+)
+
+type rejection int8
+
+const (
+    rejectionNone rejection = 0
+    rejectionServerFull rejection = 1
+    rejectionTooManyFromAddress rejection = 2
+)
+
 var networkInitialized = false
 
 func Init() Network {
@@ -70,6 +96,9 @@ func Init() Network {
     networkInitialized = true
 
     impl := &NetworkImpl{}
+    impl.addressConnections = make(map[string]int32)
+    impl.loginDeadlines = make(map[net.Conn]uint64)
+    impl.dropped = make(map[net.Conn]bool)
     impl.sync = createSync(impl)
     return impl
 }
@@ -86,6 +115,11 @@ func (impl *NetworkImpl) ProcessClients() {
         connection, err := listener.Accept()
         if err != nil { continue }
 
+        if reason := impl.admitClient(connection); reason != rejectionNone {
+            go impl.rejectClient(connection, reason) // a client that doesn't read mustn't stall the accept loop
+            continue
+        }
+
         go impl.processClient(connection)
     }
 
@@ -97,12 +131,87 @@ func (impl *NetworkImpl) ProcessClients() {
     impl.sync.terminate()
 }
 
+func connectionAddress(connection net.Conn) string {
+    host, _, err := net.SplitHostPort(connection.RemoteAddr().String())
+    if err != nil { return connection.RemoteAddr().String() }
+    return host
+}
+
+func (impl *NetworkImpl) admitClient(connection net.Conn) rejection {
+    impl.connectionsMutex.Lock()
+    defer impl.connectionsMutex.Unlock()
+
+    address := connectionAddress(connection)
+
+    if impl.connectionCount >= maxConnections { return rejectionServerFull }
+    if impl.addressConnections[address] >= maxConnectionsPerAddress { return rejectionTooManyFromAddress }
+
+    impl.connectionCount++
+    impl.addressConnections[address]++
+    impl.loginDeadlines[connection] = utils.CurrentTimeMillis() + loginDeadlineMillis
+
+    return rejectionNone
+}
+
+func (impl *NetworkImpl) rejectClient(connection net.Conn, reason rejection) {
+    _ = connection.SetWriteDeadline(time.UnixMilli(int64(utils.CurrentTimeMillis() + rejectionTimeoutMillis)))
+
+    _, _ = connection.Write(impl.packMessage(&Message{
+        flagRejected,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        []byte{byte(reason)},
+    }))
+
+    _ = connection.Close()
+}
+
+func (impl *NetworkImpl) releaseClient(connection net.Conn) {
+    impl.connectionsMutex.Lock()
+
+    address := connectionAddress(connection)
+
+    impl.connectionCount--
+    impl.addressConnections[address]--
+    if impl.addressConnections[address] <= 0 { delete(impl.addressConnections, address) }
+    delete(impl.loginDeadlines, connection)
+    delete(impl.dropped, connection)
+
+    impl.connectionsMutex.Unlock()
+}
+
+func (impl *NetworkImpl) clientAuthenticated(connection net.Conn) {
+    impl.connectionsMutex.Lock()
+    delete(impl.loginDeadlines, connection)
+    impl.connectionsMutex.Unlock()
+
+    impl.updateConnectionIdleTimeout(connection)
+}
+
+func (impl *NetworkImpl) dropClient(connection net.Conn) { // the connection's own goroutine sees the failed read and cleans up
+    impl.connectionsMutex.Lock()
+    impl.dropped[connection] = true
+    impl.connectionsMutex.Unlock()
+
+    _ = connection.SetDeadline(time.Now())
+}
+
+// only called from the connection's own goroutine, messages sent to it by others don't make it any less idle
 func (impl *NetworkImpl) updateConnectionIdleTimeout(connection net.Conn) {
-    utils.Assert(connection.SetDeadline(time.UnixMilli(int64(utils.CurrentTimeMillis() + 15 * 60 * 1000))) == nil) // 15 minutes
+    impl.connectionsMutex.Lock()
+    defer impl.connectionsMutex.Unlock()
+
+    if impl.dropped[connection] { return } // mustn't push the deadline dropClient has set
+
+    deadline, unauthenticated := impl.loginDeadlines[connection]
+    if !unauthenticated { deadline = utils.CurrentTimeMillis() + idleTimeoutMillis }
+    _ = connection.SetDeadline(time.UnixMilli(int64(deadline)))
 }
 
 func (impl *NetworkImpl) processClient(connection net.Conn) {
     impl.waitGroup.Add(1)
+    impl.updateConnectionIdleTimeout(connection)
 
     for impl.receivingMessages.Load() {
         message, err := impl.receiveMessage(connection)
@@ -114,6 +223,7 @@ func (impl *NetworkImpl) processClient(connection net.Conn) {
     }
 
     impl.sync.clientDisconnected(connection)
+    impl.releaseClient(connection)
     utils.Assert(connection.Close() == nil)
     impl.waitGroup.Done()
 }
@@ -171,7 +281,6 @@ func (impl *NetworkImpl) send(connection net.Conn, buffer []byte) utils.Triple {
     count, err := connection.Write(buffer)
     if err != nil { return utils.Negative }
 
-    impl.updateConnectionIdleTimeout(connection)
     if count == len(buffer) {
         return utils.Positive
     } else {
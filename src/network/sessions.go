@@ -0,0 +1,141 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+    "JaonedServer/utils"
+    "crypto/rand"
+    "net"
+    "reflect"
+    "sync"
+)
+
+const (
+    sessionTokenSize = 16
+    sessionLifetimeMillis = 24 * 60 * 60 * 1000 // prolonged on each resume
+)
+
+type Sessions interface {
+    createSession(connection net.Conn, client *Client) *Session
+    resumeSession(connection net.Conn, token []byte) (*Session, net.Conn) // nillable, nillable - the connection the session was taken from
+    detachSession(connection net.Conn, token []byte)
+    revokeSession(token []byte) net.Conn // nillable - the connection the session was attached to
+    revokeUserSessions(username []byte) []net.Conn
+}
+
+type SessionsImpl struct {
+    sessions map[string]*Session
+    mutex sync.Mutex
+}
+
+type Session struct {
+    token []byte
+    client *Client
+    expires uint64
+    connection net.Conn // nillable - nil while detached
+}
+
+var sessionsInitialized = false
+
+func createSessions() Sessions {
+    utils.Assert(!sessionsInitialized)
+    sessionsInitialized = true
+
+    return &SessionsImpl{
+        make(map[string]*Session),
+        sync.Mutex{},
+    }
+}
+
+func (impl *SessionsImpl) createSession(connection net.Conn, client *Client) *Session {
+    token := make([]byte, sessionTokenSize)
+    _, err := rand.Read(token)
+    utils.Assert(err == nil)
+
+    session := &Session{token, client, utils.CurrentTimeMillis() + sessionLifetimeMillis, connection}
+    client.session = token
+
+    impl.mutex.Lock()
+
+    now := utils.CurrentTimeMillis()
+    for key, other := range impl.sessions {
+        if other.connection == nil && other.expires <= now { delete(impl.sessions, key) }
+    }
+
+    impl.sessions[string(token)] = session
+
+    impl.mutex.Unlock()
+    return session
+}
+
+func (impl *SessionsImpl) resumeSession(connection net.Conn, token []byte) (*Session, net.Conn) { // nillable, nillable
+    impl.mutex.Lock()
+    defer impl.mutex.Unlock()
+
+    session := impl.sessions[string(token)]
+    if session == nil { return nil, nil }
+
+    if session.expires <= utils.CurrentTimeMillis() {
+        if session.connection == nil { delete(impl.sessions, string(token)) }
+        return nil, nil
+    }
+
+    previous := session.connection
+    session.connection = connection
+    session.expires = utils.CurrentTimeMillis() + sessionLifetimeMillis
+
+    return session, previous
+}
+
+func (impl *SessionsImpl) detachSession(connection net.Conn, token []byte) {
+    impl.mutex.Lock()
+
+    if session := impl.sessions[string(token)]; session != nil && session.connection == connection {
+        session.connection = nil
+    }
+
+    impl.mutex.Unlock()
+}
+
+func (impl *SessionsImpl) revokeSession(token []byte) net.Conn { // nillable
+    impl.mutex.Lock()
+    defer impl.mutex.Unlock()
+
+    session := impl.sessions[string(token)]
+    if session == nil { return nil }
+
+    delete(impl.sessions, string(token))
+    return session.connection
+}
+
+func (impl *SessionsImpl) revokeUserSessions(username []byte) []net.Conn {
+    impl.mutex.Lock()
+    defer impl.mutex.Unlock()
+
+    connections := make([]net.Conn, 0)
+
+    for key, session := range impl.sessions {
+        if !reflect.DeepEqual(session.client.Username, username) { continue }
+
+        if session.connection != nil { connections = append(connections, session.connection) }
+        delete(impl.sessions, key)
+    }
+
+    return connections
+}
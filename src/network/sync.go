@@ -46,14 +46,94 @@ const (
     flagClear Flag = 13
     flagSelectBoard Flag = 14
     flagGetBoardElements Flag = 15
+    flagThrottled Flag = 16
+    flagLockedOut Flag = 17
+    flagUnlockUser Flag = 18
+    flagRejected Flag = 19
+    flagResumeSession Flag = 20
+    flagLogOut Flag = 21
+    flagRevokeSessions Flag = 22
+    flagGetBoardElementsSince Flag = 23
+    flagUpdateElement Flag = 24
+    flagDeleteElement Flag = 25
+    flagElementUpdated Flag = 26
+    flagElementDeleted Flag = 27
+    flagRedo Flag = 28
+    flagBringToFront Flag = 29
+    flagSendToBack Flag = 30
+    flagRectangle Flag = 31
+    flagEllipse Flag = 32
+    flagArrow Flag = 33
+    flagStickyNote Flag = 34
+    flagCreateLayer Flag = 35
+    flagUpdateLayer Flag = 36
+    flagDeleteLayer Flag = 37
+    flagGetLayers Flag = 38
+    flagMoveToLayer Flag = 39
+    flagGetLayerElements Flag = 40
+    flagGroupElements Flag = 41
+    flagUngroup Flag = 42
+    flagMoveGroup Flag = 43
+    flagDeleteGroup Flag = 44
+    flagGetBoardElementsAt Flag = 45
+    flagCreateCheckpoint Flag = 46
+    flagGetCheckpoints Flag = 47
+    flagRestoreCheckpoint Flag = 48
+    flagGetCheckpointElements Flag = 49
+    flagForkBoard Flag = 50
+    flagSetTemplate Flag = 51
+    flagGetTemplates Flag = 52
+    flagInstantiateTemplate Flag = 53
+    flagUpdateBoard Flag = 54
+    flagBoardUpdated Flag = 55
+    flagGetTrash Flag = 56
+    flagRestoreBoard Flag = 57
+    flagPurgeBoard Flag = 58
+    flagTransferBoard Flag = 59
+    flagDeleteAccount Flag = 60
+    flagListBoards Flag = 61
+    flagCreateWorkspace Flag = 62
+    flagGetWorkspaces Flag = 63
+    flagAddWorkspaceMember Flag = 64
+    flagCreateFolder Flag = 65
+    flagRenameFolder Flag = 66
+    flagMoveFolder Flag = 67
+    flagGetFolders Flag = 68
+    flagPlaceBoard Flag = 69
+    flagGetFolderBoards Flag = 70
+    flagLockBoard Flag = 71
+    flagBoardLockChanged Flag = 72
+    flagUnlockAddress Flag = 73
+    flagAddElementWithKey Flag = 74
 
     maxCredentialSize = database.MaxCredentialSize
+    authorshipSize = maxCredentialSize + 8
+    maxAddressSize = 64
+    tombstoneType database.ElementType = -1
+)
+
+const (
+    usernameFailuresBeforeLockout = 3
+    addressFailuresBeforeLockout = 10
+    lockoutBaseMillis = 30 * 1000 // doubles with each subsequent failure
+    maxLockoutMillis = 24 * 60 * 60 * 1000
+    failuresResetMillis = 24 * 60 * 60 * 1000 // failures older than that are forgotten
 )
 
 type Sync interface {
     terminate()
     sendBytes(connection net.Conn, bytes []byte, flag Flag)
+    lockedOut(subject []byte, kind database.LockoutKind) uint64
+    loginFailed(subject []byte, kind database.LockoutKind, threshold int32)
     logIn(connection net.Conn, message *Message) bool
+    packSession(session *Session) []byte
+    resumeSession(connection net.Conn, message *Message) bool
+    logOut(connection net.Conn) bool
+    revokeSessions(connection net.Conn, message *Message) bool
+    deleteAccount(connection net.Conn, message *Message) bool
+    unlockUser(connection net.Conn, message *Message) bool
+    unlockAddress(connection net.Conn, message *Message) bool
+    removeLockout(connection net.Conn, subject []byte, kind database.LockoutKind, flag Flag)
     register(connection net.Conn, message *Message) bool
     shutdown(connection net.Conn) bool
     processPendingMessages(connection net.Conn, message *Message) []byte // nillable
@@ -62,15 +142,77 @@ type Sync interface {
     createBoard(connection net.Conn, message *Message) bool
     getBoard(connection net.Conn, message *Message) bool
     getBoards(connection net.Conn) bool
+    packBoardSummary(board *database.Board) []byte
+    listBoards(connection net.Conn, message *Message) bool
+    updateBoard(connection net.Conn, message *Message) bool
     deleteBoard(connection net.Conn, message *Message) bool
+    getTrash(connection net.Conn) bool
+    restoreBoard(connection net.Conn, message *Message) bool
+    purgeBoard(connection net.Conn, message *Message) bool
+    transferBoard(connection net.Conn, message *Message) bool
+    lockBoard(connection net.Conn, message *Message) bool
+    forkBoard(connection net.Conn, message *Message) bool
+    setTemplate(connection net.Conn, message *Message) bool
+    getTemplates(connection net.Conn) bool
+    instantiateTemplate(connection net.Conn, message *Message) bool
+    packWorkspace(workspace *database.Workspace) []byte
+    packFolder(folder *database.Folder) []byte
+    createWorkspace(connection net.Conn, message *Message) bool
+    getWorkspaces(connection net.Conn) bool
+    addWorkspaceMember(connection net.Conn, message *Message) bool
+    createFolder(connection net.Conn, message *Message) bool
+    renameFolder(connection net.Conn, message *Message) bool
+    moveFolder(connection net.Conn, message *Message) bool
+    getFolders(connection net.Conn, message *Message) bool
+    placeBoard(connection net.Conn, message *Message) bool
+    getFolderBoards(connection net.Conn, message *Message) bool
+    packElementAck(element *database.Element) []byte
+    addElement(connection net.Conn, message *Message, xType database.ElementType, flag Flag) bool
+    addElementWithKey(connection net.Conn, message *Message) bool
+    storeElement(connection net.Conn, client *Client, element database.Element, flag Flag)
     pointsSet(connection net.Conn, message *Message) bool
     line(connection net.Conn, message *Message) bool
     text(connection net.Conn, message *Message) bool
     image(connection net.Conn, message *Message) bool
+    rectangle(connection net.Conn, message *Message) bool
+    ellipse(connection net.Conn, message *Message) bool
+    arrow(connection net.Conn, message *Message) bool
+    stickyNote(connection net.Conn, message *Message) bool
+    broadcast(connection net.Conn, board int32, bytes []byte, flag Flag)
+    updateElement(connection net.Conn, message *Message) bool
+    deleteElement(connection net.Conn, message *Message) bool
+    reorderElement(connection net.Conn, message *Message, toFront bool, flag Flag) bool
+    broadcastChanges(board int32, changes *database.Changes)
+    failureReply(client *Client, board int32) []byte
+    applyChanges(connection net.Conn, flag Flag, change func(client *Client) *database.Changes) bool
     undo(connection net.Conn) bool
+    redo(connection net.Conn) bool
     clear(connection net.Conn) bool
     selectBoard(connection net.Conn, message *Message) bool
-    boardElements(connection net.Conn) bool
+    packAuthorship(element *database.Element, bytes []byte)
+    sendElements(connection net.Conn, elements []*database.Element, withAuthorship bool, flag Flag)
+    boardElements(connection net.Conn, message *Message) bool
+    boardElementsAt(connection net.Conn, message *Message) bool
+    packCheckpoint(checkpoint *database.Checkpoint) []byte
+    createCheckpoint(connection net.Conn, message *Message) bool
+    getCheckpoints(connection net.Conn) bool
+    restoreCheckpoint(connection net.Conn, message *Message) bool
+    checkpointElements(connection net.Conn, message *Message) bool
+    packElementRecord(element *database.Element) []byte
+    boardElementsSince(connection net.Conn, message *Message) bool
+    packLayer(layer *database.Layer) []byte
+    unpackLayer(bytes []byte) *database.Layer
+    createLayer(connection net.Conn, message *Message) bool
+    updateLayer(connection net.Conn, message *Message) bool
+    deleteLayer(connection net.Conn, message *Message) bool
+    getLayers(connection net.Conn) bool
+    moveToLayer(connection net.Conn, message *Message) bool
+    layerElements(connection net.Conn, message *Message) bool
+    groupElements(connection net.Conn, message *Message) bool
+    ungroup(connection net.Conn, message *Message) bool
+    moveGroup(connection net.Conn, message *Message) bool
+    deleteGroup(connection net.Conn, message *Message) bool
+    throttle(connection net.Conn, message *Message) utils.Triple
     routeMessage(connection net.Conn, message *Message) bool
     clientDisconnected(connection net.Conn)
 }
@@ -79,6 +221,9 @@ type SyncImpl struct {
     db database.Database
     network Network
     clients Clients
+    limiter Limiter
+    sessions Sessions
+    maintenance Maintenance
 }
 
 var syncInitialized = false
@@ -87,14 +232,23 @@ func createSync(network Network) Sync {
     utils.Assert(!syncInitialized)
     syncInitialized = true
 
-    return &SyncImpl{
-        database.Init(),
+    db := database.Init()
+
+    impl := &SyncImpl{
+        db,
         network,
         createClients(),
+        createLimiter(),
+        createSessions(),
+        createMaintenance(db),
     }
+
+    impl.maintenance.start()
+    return impl
 }
 
 func (impl *SyncImpl) terminate() {
+    impl.maintenance.stop()
     impl.db.Close()
 }
 
@@ -126,6 +280,32 @@ func (impl *SyncImpl) sendBytes(connection net.Conn, bytes []byte, flag Flag) {
     }
 }
 
+func (impl *SyncImpl) lockedOut(subject []byte, kind database.LockoutKind) uint64 { // remaining millis, zero if not locked
+    lockout := impl.db.GetLockout(subject, kind)
+    now := utils.CurrentTimeMillis()
+
+    if lockout == nil || lockout.LockedUntil <= now { return 0 }
+    return lockout.LockedUntil - now
+}
+
+func (impl *SyncImpl) loginFailed(subject []byte, kind database.LockoutKind, threshold int32) {
+    now := utils.CurrentTimeMillis()
+
+    lockout := impl.db.GetLockout(subject, kind)
+    if lockout == nil || now - lockout.LastFailure > failuresResetMillis {
+        lockout = &database.Lockout{Subject: subject, Kind: kind}
+    }
+
+    lockout.Failures++
+    lockout.LastFailure = now
+
+    if lockout.Failures >= threshold {
+        lockout.LockedUntil = now + min(uint64(lockoutBaseMillis) << min(lockout.Failures - threshold, 16), maxLockoutMillis)
+    }
+
+    impl.db.SetLockout(lockout)
+}
+
 func (impl *SyncImpl) logIn(connection net.Conn, message *Message) bool {
     utils.Assert(message.body != nil && len(message.body) == maxCredentialSize * 2)
 
@@ -142,6 +322,22 @@ func (impl *SyncImpl) logIn(connection net.Conn, message *Message) bool {
 
     username := message.body[0:maxCredentialSize]
     password := message.body[maxCredentialSize:(maxCredentialSize + maxCredentialSize)]
+    address := []byte(connectionAddress(connection))
+
+    remaining := max(impl.lockedOut(username, database.LockoutUsername), impl.lockedOut(address, database.LockoutAddress))
+    if remaining > 0 {
+        body := make([]byte, 8)
+        copy(unsafe.Slice(&(body[0]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&remaining)), 8))
+
+        impl.network.sendMessage(connection, &Message{
+            flagLockedOut,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            body,
+        })
+        return true
+    }
 
     user := impl.db.FindUser(username)
     var authenticated bool
@@ -155,9 +351,16 @@ func (impl *SyncImpl) logIn(connection net.Conn, message *Message) bool {
     var body []byte
     if !authenticated {
         body = nil
+        impl.loginFailed(username, database.LockoutUsername, usernameFailuresBeforeLockout)
+        impl.loginFailed(address, database.LockoutAddress, addressFailuresBeforeLockout)
     } else {
-        body = make([]byte, 1)
-        impl.clients.addClient(connection, &Client{user, make(map[int64][]*Message), -1})
+        impl.db.RemoveLockout(username, database.LockoutUsername)
+
+        client := &Client{user, make(map[int64][]*Message), -1, nil}
+        body = impl.packSession(impl.sessions.createSession(connection, client))
+
+        impl.clients.addClient(connection, client)
+        impl.network.clientAuthenticated(connection)
     }
 
     impl.network.sendMessage(connection, &Message{
@@ -171,6 +374,202 @@ func (impl *SyncImpl) logIn(connection net.Conn, message *Message) bool {
     return !authenticated
 }
 
+func (impl *SyncImpl) packSession(session *Session) []byte {
+    bytes := make([]byte, sessionTokenSize + 8)
+    copy(unsafe.Slice(&(bytes[0]), sessionTokenSize), session.token)
+    copy(unsafe.Slice(&(bytes[sessionTokenSize]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(session.expires))), 8))
+    return bytes
+}
+
+func (impl *SyncImpl) resumeSession(connection net.Conn, message *Message) bool {
+    if message.body == nil || len(message.body) != sessionTokenSize { return true }
+
+    if impl.clients.getClient(connection) != nil {
+        impl.network.sendMessage(connection, &Message{
+            flagResumeSession,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+        return true
+    }
+
+    session, previous := impl.sessions.resumeSession(connection, message.body)
+
+    if previous != nil {
+        impl.clients.removeClient(previous)
+        impl.network.dropClient(previous)
+    }
+
+    var user *database.User = nil
+    if session != nil { user = impl.db.FindUser(session.client.Username) }
+
+    if user == nil {
+        if session != nil { impl.sessions.revokeSession(session.token) }
+
+        impl.network.sendMessage(connection, &Message{
+            flagResumeSession,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+        return true
+    }
+
+    session.client.User = user
+    impl.clients.addClient(connection, session.client)
+    impl.network.clientAuthenticated(connection)
+
+    impl.network.sendMessage(connection, &Message{
+        flagResumeSession,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        impl.packSession(session),
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) logOut(connection net.Conn) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    impl.sessions.revokeSession(client.session)
+
+    impl.network.sendMessage(connection, &Message{
+        flagLogOut,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        []byte{1},
+    })
+
+    return true
+}
+
+func (impl *SyncImpl) revokeSessions(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    username := client.Username
+    if message.body != nil {
+        if len(message.body) != maxCredentialSize { return true }
+        username = message.body
+    }
+
+    if !client.IsAdmin && !reflect.DeepEqual(username, client.Username) {
+        impl.network.sendMessage(connection, &Message{
+            flagRevokeSessions,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+        return false
+    }
+
+    revokedOwn := false
+
+    for _, revoked := range impl.sessions.revokeUserSessions(username) {
+        if revoked == connection {
+            revokedOwn = true
+            continue
+        }
+
+        impl.clients.removeClient(revoked)
+        impl.network.dropClient(revoked)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagRevokeSessions,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        []byte{1},
+    })
+
+    return revokedOwn
+}
+
+// the request consists of the user's password, optionally followed by the username of whom to give the user's boards to,
+// otherwise they go to the trash, the user's other connections are dropped and this one is closed after the reply
+func (impl *SyncImpl) deleteAccount(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || (len(message.body) != maxCredentialSize && len(message.body) != maxCredentialSize * 2) { return true }
+
+    var heir []byte = nil
+    if len(message.body) == maxCredentialSize * 2 { heir = message.body[maxCredentialSize:] }
+
+    user := impl.db.FindUser(client.Username)
+
+    if user == nil || !reflect.DeepEqual(message.body[:maxCredentialSize], user.Password) || !impl.db.DeleteAccount(client.Username, heir) {
+        impl.network.sendMessage(connection, &Message{
+            flagDeleteAccount,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+        return false
+    }
+
+    for _, revoked := range impl.sessions.revokeUserSessions(client.Username) {
+        if revoked == connection { continue }
+
+        impl.clients.removeClient(revoked)
+        impl.network.dropClient(revoked)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagDeleteAccount,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        []byte{1},
+    })
+
+    return true
+}
+
+// clears only the username lockout, the address one is kept separately as other users may be failing from the same address, see unlockAddress
+func (impl *SyncImpl) unlockUser(connection net.Conn, message *Message) bool {
+    if impl.clients.getClient(connection) == nil { return true }
+    if message.body == nil || len(message.body) != maxCredentialSize { return true }
+
+    impl.removeLockout(connection, message.body, database.LockoutUsername, flagUnlockUser)
+    return false
+}
+
+// the body is the address as the server sees it, without the port
+func (impl *SyncImpl) unlockAddress(connection net.Conn, message *Message) bool {
+    if impl.clients.getClient(connection) == nil { return true }
+    if message.body == nil || len(message.body) > maxAddressSize { return true }
+
+    impl.removeLockout(connection, message.body, database.LockoutAddress, flagUnlockAddress)
+    return false
+}
+
+func (impl *SyncImpl) removeLockout(connection net.Conn, subject []byte, kind database.LockoutKind, flag Flag) {
+    var result []byte
+    if impl.clients.getClient(connection).IsAdmin && impl.db.RemoveLockout(subject, kind) {
+        result = []byte{1}
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flag,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+}
+
 func (impl *SyncImpl) register(connection net.Conn, message *Message) bool {
     utils.Assert(message.body != nil && len(message.body) == maxCredentialSize * 2)
 
@@ -341,9 +740,102 @@ func (impl *SyncImpl) getBoards(connection net.Conn) bool {
     return false
 }
 
+// owner - maxCredentialSize (zeroed if none), created - 8 bytes, updated - 8, members - 4, elements - 4, locked - 1, then the packed board
+func (impl *SyncImpl) packBoardSummary(board *database.Board) []byte {
+    packed := impl.packBoard(board)
+
+    bytes := make([]byte, maxCredentialSize + 8 + 8 + 4 + 4 + 1 + len(packed))
+    copy(unsafe.Slice(&(bytes[0]), maxCredentialSize), board.Owner)
+    copy(unsafe.Slice(&(bytes[maxCredentialSize]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(board.Created))), 8))
+    copy(unsafe.Slice(&(bytes[maxCredentialSize + 8]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(board.Updated))), 8))
+    copy(unsafe.Slice(&(bytes[maxCredentialSize + 16]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(board.Members))), 4))
+    copy(unsafe.Slice(&(bytes[maxCredentialSize + 20]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(board.Elements))), 4))
+    if board.Locked { bytes[maxCredentialSize + 24] = 1 }
+    copy(bytes[maxCredentialSize + 25:], packed)
+    return bytes
+}
+
+// the request consists of the offset (4 bytes), the page size (4), the sort order (1) and optionally a part of the title to search for,
+// replies with a summary per board of the page the same way as for flagGetBoards
+func (impl *SyncImpl) listBoards(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) < 9 || len(message.body) > 9 + database.MaxBoardTitleSize { return true }
+
+    query := &database.BoardQuery{Sort: database.BoardSort(message.body[8])}
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&(query.Offset))), 4), unsafe.Slice(&(message.body[0]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&(query.Limit))), 4), unsafe.Slice(&(message.body[4]), 4))
+    if len(message.body) > 9 { query.Title = message.body[9:] }
+
+    boards := impl.db.ListBoards(client.Username, query)
+
+    if len(boards) == 0 {
+        impl.network.sendMessage(connection, &Message{
+            flagListBoards,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+    } else {
+        var index int32 = 0
+        timestamp := int64(utils.CurrentTimeMillis())
+
+        for _, board := range boards {
+            impl.network.sendMessage(connection, &Message{
+                flagListBoards,
+                index,
+                int32(len(boards)),
+                timestamp,
+                impl.packBoardSummary(board),
+            })
+            index++
+        }
+    }
+
+    return false
+}
+
+// the request is a packed board, the other members of it who are online get it with flagBoardUpdated
+func (impl *SyncImpl) updateBoard(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) < 12 { return true }
+
+    var size int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&size)), 4), unsafe.Slice(&(message.body[8]), 4))
+    if size < 0 || size > database.MaxBoardTitleSize || int(size) != len(message.body) - 12 { return true }
+
+    board := impl.unpackBoard(message.body)
+
+    var result []byte
+    if impl.db.UpdateBoard(client.Username, board) {
+        result = []byte{1}
+
+        if members := impl.db.GetBoardMembers(board.Id); members != nil {
+            for _, member := range impl.clients.usersConnections(members, connection) {
+                impl.sendBytes(member, message.body, flagBoardUpdated)
+            }
+        }
+    } else {
+        result = impl.failureReply(client, board.Id)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagUpdateBoard,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
 func (impl *SyncImpl) deleteBoard(connection net.Conn, message *Message) bool {
     client := impl.clients.getClient(connection)
     if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
 
     var id int32
     copy(unsafe.Slice((*byte) (unsafe.Pointer(&(id))), 4), unsafe.Slice(&(message.body[0]), 4))
@@ -352,7 +844,7 @@ func (impl *SyncImpl) deleteBoard(connection net.Conn, message *Message) bool {
     if impl.db.RemoveBoard(client.Username, id) {
         result = []byte{1}
     } else {
-        result = nil
+        result = impl.failureReply(client, id)
     }
 
     impl.network.sendMessage(connection, &Message{
@@ -366,92 +858,1248 @@ func (impl *SyncImpl) deleteBoard(connection net.Conn, message *Message) bool {
     return false
 }
 
-func (impl *SyncImpl) pointsSet(connection net.Conn, message *Message) bool {
+func (impl *SyncImpl) getTrash(connection net.Conn) bool {
     client := impl.clients.getClient(connection)
     if client == nil { return true }
 
-    bytes := impl.processPendingMessages(connection, message)
-    if bytes == nil { return false }
+    boards := impl.db.GetTrash(client.Username)
+
+    if len(boards) == 0 {
+        impl.network.sendMessage(connection, &Message{
+            flagGetTrash,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+    } else {
+        var index int32 = 0
+        timestamp := int64(utils.CurrentTimeMillis())
+
+        for _, board := range boards {
+            impl.network.sendMessage(connection, &Message{
+                flagGetTrash,
+                index,
+                int32(len(boards)),
+                timestamp,
+                impl.packBoard(board),
+            })
+            index++
+        }
+    }
 
-    impl.db.AddElement(database.Element{database.ElementPointsSet, bytes}, client.board)
     return false
 }
 
-func (impl *SyncImpl) line(connection net.Conn, message *Message) bool {
+func (impl *SyncImpl) restoreBoard(connection net.Conn, message *Message) bool {
     client := impl.clients.getClient(connection)
     if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
 
-    bytes := impl.processPendingMessages(connection, message)
-    if bytes == nil { return false }
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if impl.db.RestoreBoard(client.Username, id) {
+        result = []byte{1}
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagRestoreBoard,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
 
-    impl.db.AddElement(database.Element{database.ElementLine, bytes}, client.board)
     return false
 }
 
-func (impl *SyncImpl) text(connection net.Conn, message *Message) bool {
+// admin only, skips the trash
+func (impl *SyncImpl) purgeBoard(connection net.Conn, message *Message) bool {
     client := impl.clients.getClient(connection)
     if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
 
-    bytes := impl.processPendingMessages(connection, message)
-    if bytes == nil { return false }
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if client.IsAdmin && impl.db.PurgeBoard(id) {
+        result = []byte{1}
+    } else {
+        result = impl.failureReply(client, id)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagPurgeBoard,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// the request consists of the board's id followed by the new owner's username
+func (impl *SyncImpl) transferBoard(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 + maxCredentialSize { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if impl.db.TransferBoard(client.Username, id, message.body[4:]) {
+        result = []byte{1}
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagTransferBoard,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// the request consists of the board's id followed by whether to lock it (1 byte), the request itself is echoed to the other members
+// of the board who are online with flagBoardLockChanged, changes to a locked board fail for everyone until it's unlocked, see failureReply
+func (impl *SyncImpl) lockBoard(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 5 { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if impl.db.SetBoardLocked(client.Username, id, message.body[4] != 0) {
+        result = []byte{1}
+
+        if members := impl.db.GetBoardMembers(id); members != nil {
+            for _, member := range impl.clients.usersConnections(members, connection) {
+                impl.sendBytes(member, message.body, flagBoardLockChanged)
+            }
+        }
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagLockBoard,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// the request consists of the board's id optionally followed by a moment of its history (8 bytes), replies with the new board
+func (impl *SyncImpl) forkBoard(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || (len(message.body) != 4 && len(message.body) != 12) { return true }
+
+    var id int32
+    var timestamp uint64 = 0
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+    if len(message.body) == 12 { copy(unsafe.Slice((*byte) (unsafe.Pointer(&timestamp)), 8), unsafe.Slice(&(message.body[4]), 8)) }
+
+    var result []byte
+    if board := impl.db.ForkBoard(client.Username, id, timestamp); board != nil {
+        result = impl.packBoard(board)
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagForkBoard,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// the request consists of the board's id followed by the template scope (1 byte)
+func (impl *SyncImpl) setTemplate(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 5 { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if impl.db.SetBoardTemplate(client.Username, client.IsAdmin, id, database.TemplateScope(message.body[4])) {
+        result = []byte{1}
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagSetTemplate,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) getTemplates(connection net.Conn) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    templates := impl.db.GetTemplates(client.Username)
+
+    if len(templates) == 0 {
+        impl.network.sendMessage(connection, &Message{
+            flagGetTemplates,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+    } else {
+        var index int32 = 0
+        timestamp := int64(utils.CurrentTimeMillis())
+
+        for _, template := range templates {
+            impl.network.sendMessage(connection, &Message{
+                flagGetTemplates,
+                index,
+                int32(len(templates)),
+                timestamp,
+                impl.packBoard(template),
+            })
+            index++
+        }
+    }
+
+    return false
+}
+
+// replies with the board created from the template
+func (impl *SyncImpl) instantiateTemplate(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if board := impl.db.InstantiateTemplate(client.Username, id); board != nil {
+        result = impl.packBoard(board)
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagInstantiateTemplate,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// id - 4 bytes, owner - maxCredentialSize, then the name
+func (impl *SyncImpl) packWorkspace(workspace *database.Workspace) []byte {
+    bytes := make([]byte, 4 + maxCredentialSize + len(workspace.Name))
+    copy(unsafe.Slice(&(bytes[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(workspace.Id))), 4))
+    copy(unsafe.Slice(&(bytes[4]), maxCredentialSize), workspace.Owner)
+    copy(bytes[4 + maxCredentialSize:], workspace.Name)
+    return bytes
+}
+
+// id - 4 bytes, parent - 4, workspace - 4, then the name
+func (impl *SyncImpl) packFolder(folder *database.Folder) []byte {
+    bytes := make([]byte, 4 + 4 + 4 + len(folder.Name))
+    copy(unsafe.Slice(&(bytes[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(folder.Id))), 4))
+    copy(unsafe.Slice(&(bytes[4]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(folder.Parent))), 4))
+    copy(unsafe.Slice(&(bytes[8]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(folder.Workspace))), 4))
+    copy(bytes[12:], folder.Name)
+    return bytes
+}
+
+// the request is the workspace's name, replies with the created workspace
+func (impl *SyncImpl) createWorkspace(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) > database.MaxFolderNameSize { return true }
+
+    var result []byte
+    if workspace := impl.db.AddWorkspace(client.Username, message.body); workspace != nil {
+        result = impl.packWorkspace(workspace)
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagCreateWorkspace,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) getWorkspaces(connection net.Conn) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    workspaces := impl.db.GetWorkspaces(client.Username)
+
+    if len(workspaces) == 0 {
+        impl.network.sendMessage(connection, &Message{
+            flagGetWorkspaces,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+    } else {
+        var index int32 = 0
+        timestamp := int64(utils.CurrentTimeMillis())
+
+        for _, workspace := range workspaces {
+            impl.network.sendMessage(connection, &Message{
+                flagGetWorkspaces,
+                index,
+                int32(len(workspaces)),
+                timestamp,
+                impl.packWorkspace(workspace),
+            })
+            index++
+        }
+    }
+
+    return false
+}
+
+// the request consists of the workspace's id followed by the new member's username
+func (impl *SyncImpl) addWorkspaceMember(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 + maxCredentialSize { return true }
+
+    var workspace int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&workspace)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if impl.db.AddWorkspaceMember(client.Username, workspace, message.body[4:]) {
+        result = []byte{1}
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagAddWorkspaceMember,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// the request consists of the parent folder's id, the workspace's id (both zero if none) and the name, replies with the created folder
+func (impl *SyncImpl) createFolder(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) < 8 || len(message.body) > 8 + database.MaxFolderNameSize { return true }
+
+    folder := &database.Folder{Name: message.body[8:]}
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&(folder.Parent))), 4), unsafe.Slice(&(message.body[0]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&(folder.Workspace))), 4), unsafe.Slice(&(message.body[4]), 4))
+
+    var result []byte
+    if added := impl.db.AddFolder(client.Username, folder); added != nil {
+        result = impl.packFolder(added)
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagCreateFolder,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// the request consists of the folder's id followed by its new name
+func (impl *SyncImpl) renameFolder(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) < 4 || len(message.body) > 4 + database.MaxFolderNameSize { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if impl.db.RenameFolder(client.Username, id, message.body[4:]) {
+        result = []byte{1}
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagRenameFolder,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// the request consists of the folder's id followed by the new parent's one, which is zero for the root
+func (impl *SyncImpl) moveFolder(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 8 { return true }
+
+    var id, parent int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&parent)), 4), unsafe.Slice(&(message.body[4]), 4))
+
+    var result []byte
+    if impl.db.MoveFolder(client.Username, id, parent) {
+        result = []byte{1}
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagMoveFolder,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// the request consists of the workspace's id followed by the parent folder's one, zeros stand for the personal ones and the root
+func (impl *SyncImpl) getFolders(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 8 { return true }
+
+    var workspace, parent int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&workspace)), 4), unsafe.Slice(&(message.body[0]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&parent)), 4), unsafe.Slice(&(message.body[4]), 4))
+
+    folders := impl.db.GetFolders(client.Username, workspace, parent)
+
+    if len(folders) == 0 {
+        impl.network.sendMessage(connection, &Message{
+            flagGetFolders,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+    } else {
+        var index int32 = 0
+        timestamp := int64(utils.CurrentTimeMillis())
+
+        for _, folder := range folders {
+            impl.network.sendMessage(connection, &Message{
+                flagGetFolders,
+                index,
+                int32(len(folders)),
+                timestamp,
+                impl.packFolder(folder),
+            })
+            index++
+        }
+    }
+
+    return false
+}
+
+// the request consists of the board's id followed by the folder's one
+func (impl *SyncImpl) placeBoard(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 8 { return true }
+
+    var board, folder int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&board)), 4), unsafe.Slice(&(message.body[0]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&folder)), 4), unsafe.Slice(&(message.body[4]), 4))
+
+    var result []byte
+    if impl.db.PlaceBoard(client.Username, board, folder) {
+        result = []byte{1}
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagPlaceBoard,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) getFolderBoards(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
+
+    var folder int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&folder)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    boards := impl.db.GetFolderBoards(client.Username, folder)
+
+    if len(boards) == 0 {
+        impl.network.sendMessage(connection, &Message{
+            flagGetFolderBoards,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+    } else {
+        var index int32 = 0
+        timestamp := int64(utils.CurrentTimeMillis())
+
+        for _, board := range boards {
+            impl.network.sendMessage(connection, &Message{
+                flagGetFolderBoards,
+                index,
+                int32(len(boards)),
+                timestamp,
+                impl.packBoard(board),
+            })
+            index++
+        }
+    }
+
+    return false
+}
+
+func (impl *SyncImpl) packElementAck(element *database.Element) []byte {
+    bytes := make([]byte, 4 + 8)
+    copy(unsafe.Slice(&(bytes[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Id))), 4))
+    copy(unsafe.Slice(&(bytes[4]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Sequence))), 8))
+    return bytes
+}
+
+func (impl *SyncImpl) addElement(connection net.Conn, message *Message, xType database.ElementType, flag Flag) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    bytes := impl.processPendingMessages(connection, message)
+    if bytes == nil { return false }
+
+    impl.storeElement(connection, client, database.Element{Type: xType, Bytes: bytes}, flag)
+    return false
+}
+
+// the uploaded bytes consist of the element's idempotency key, its type and its bytes, retries carrying the same key are answered with the element stored the first time
+func (impl *SyncImpl) addElementWithKey(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    bytes := impl.processPendingMessages(connection, message)
+    if bytes == nil { return false }
+    if len(bytes) < database.IdempotencyKeySize + 4 { return true }
+
+    var xType database.ElementType
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&xType)), 4), unsafe.Slice(&(bytes[database.IdempotencyKeySize]), 4))
+
+    var key []byte = nil
+    if !reflect.DeepEqual(bytes[:database.IdempotencyKeySize], make([]byte, database.IdempotencyKeySize)) { key = bytes[:database.IdempotencyKeySize] }
+
+    impl.storeElement(connection, client, database.Element{Type: xType, Bytes: bytes[(database.IdempotencyKeySize + 4):], Key: key}, flagAddElementWithKey)
+    return false
+}
+
+func (impl *SyncImpl) storeElement(connection net.Conn, client *Client, element database.Element, flag Flag) {
+    var result []byte
+    if added := impl.db.AddElement(client.Username, element, client.board); added != nil {
+        result = impl.packElementAck(added)
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flag,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+}
+
+func (impl *SyncImpl) pointsSet(connection net.Conn, message *Message) bool {
+    return impl.addElement(connection, message, database.ElementPointsSet, flagPointsSet)
+}
+
+func (impl *SyncImpl) line(connection net.Conn, message *Message) bool {
+    return impl.addElement(connection, message, database.ElementLine, flagLine)
+}
+
+func (impl *SyncImpl) text(connection net.Conn, message *Message) bool {
+    return impl.addElement(connection, message, database.ElementText, flagText)
+}
+
+func (impl *SyncImpl) image(connection net.Conn, message *Message) bool {
+    return impl.addElement(connection, message, database.ElementImage, flagImage)
+}
+
+func (impl *SyncImpl) rectangle(connection net.Conn, message *Message) bool {
+    return impl.addElement(connection, message, database.ElementRectangle, flagRectangle)
+}
+
+func (impl *SyncImpl) ellipse(connection net.Conn, message *Message) bool {
+    return impl.addElement(connection, message, database.ElementEllipse, flagEllipse)
+}
+
+func (impl *SyncImpl) arrow(connection net.Conn, message *Message) bool {
+    return impl.addElement(connection, message, database.ElementArrow, flagArrow)
+}
+
+func (impl *SyncImpl) stickyNote(connection net.Conn, message *Message) bool {
+    return impl.addElement(connection, message, database.ElementStickyNote, flagStickyNote)
+}
+
+func (impl *SyncImpl) broadcast(connection net.Conn, board int32, bytes []byte, flag Flag) { // to everyone else who has the board selected
+    for _, viewer := range impl.clients.boardViewers(board, connection) {
+        impl.sendBytes(viewer, bytes, flag)
+    }
+}
+
+// the request consists of the element's id followed by its new bytes, the element's type stays the same
+func (impl *SyncImpl) updateElement(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    bytes := impl.processPendingMessages(connection, message)
+    if bytes == nil { return false }
+    if len(bytes) <= 4 { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(bytes[0]), 4))
+
+    var result []byte
+    if element := impl.db.UpdateElement(client.Username, client.board, id, bytes[4:]); element != nil {
+        result = impl.packElementAck(element)
+        impl.broadcast(connection, client.board, impl.packElementRecord(element), flagElementUpdated)
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagUpdateElement,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) deleteElement(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if tombstone := impl.db.RemoveElement(client.Username, client.board, id); tombstone != nil {
+        result = impl.packElementAck(&database.Element{Id: tombstone.ElementId, Sequence: tombstone.Sequence})
+        impl.broadcast(connection, client.board, result, flagElementDeleted)
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagDeleteElement,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) reorderElement(connection net.Conn, message *Message, toFront bool, flag Flag) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if element := impl.db.ReorderElement(client.Username, client.board, id, toFront); element != nil {
+        result = impl.packElementAck(element)
+        impl.broadcast(connection, client.board, impl.packElementRecord(element), flagElementUpdated)
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flag,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) broadcastChanges(board int32, changes *database.Changes) { // to everyone who has the board selected
+    for _, element := range changes.Elements {
+        impl.broadcast(nil, board, impl.packElementRecord(element), flagElementUpdated)
+    }
+
+    for _, tombstone := range changes.Tombstones {
+        impl.broadcast(nil, board, impl.packElementAck(&database.Element{Id: tombstone.ElementId, Sequence: tombstone.Sequence}), flagElementDeleted)
+    }
+}
+
+// replies with the board's new sequence, the affected elements are then broadcast as updated or deleted
+// a change to a locked board is answered with a single zero byte, other failures get no body
+func (impl *SyncImpl) failureReply(client *Client, board int32) []byte { // nillable
+    var username []byte = nil
+    if !client.IsAdmin { username = client.Username }
+
+    if impl.db.IsBoardLocked(username, board) { return []byte{0} }
+    return nil
+}
+
+func (impl *SyncImpl) applyChanges(connection net.Conn, flag Flag, change func(client *Client) *database.Changes) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    var result []byte
+    if changes := change(client); changes != nil {
+        result = make([]byte, 8)
+        copy(unsafe.Slice(&(result[0]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(changes.Sequence))), 8))
+        impl.broadcastChanges(client.board, changes)
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flag,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) undo(connection net.Conn) bool {
+    return impl.applyChanges(connection, flagUndo, func(client *Client) *database.Changes {
+        return impl.db.Undo(client.Username, client.board)
+    })
+}
+
+func (impl *SyncImpl) redo(connection net.Conn) bool {
+    return impl.applyChanges(connection, flagRedo, func(client *Client) *database.Changes {
+        return impl.db.Redo(client.Username, client.board)
+    })
+}
+
+func (impl *SyncImpl) clear(connection net.Conn) bool {
+    return impl.applyChanges(connection, flagClear, func(client *Client) *database.Changes {
+        return impl.db.RemoveAllElements(client.Username, client.board)
+    })
+}
+
+func (impl *SyncImpl) selectBoard(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&(id))), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    if impl.db.GetBoard(client.Username, id) != nil {
+        client.board = id
+        return false
+    }
+
+    client.board = -1 // only members can select a board, the client gets told it has none selected now
+
+    impl.network.sendMessage(connection, &Message{
+        flagSelectBoard,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        nil,
+    })
+
+    return false
+}
+
+// the author's username (zeroed if unknown) followed by the creation time
+func (impl *SyncImpl) packAuthorship(element *database.Element, bytes []byte) {
+    copy(unsafe.Slice(&(bytes[0]), maxCredentialSize), element.Author)
+    copy(unsafe.Slice(&(bytes[maxCredentialSize]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Timestamp))), 8))
+}
+
+// each element goes as its type followed by its bytes, optionally with its authorship in between, then an empty message
+func (impl *SyncImpl) sendElements(connection net.Conn, elements []*database.Element, withAuthorship bool, flag Flag) {
+    for _, element := range elements {
+        var bytes []byte
+
+        if withAuthorship {
+            bytes = make([]byte, 4 + authorshipSize + len(element.Bytes))
+            impl.packAuthorship(element, bytes[4:])
+            copy(bytes[4 + authorshipSize:], element.Bytes)
+        } else {
+            bytes = make([]byte, 4 + len(element.Bytes))
+            copy(bytes[4:], element.Bytes)
+        }
+        copy(unsafe.Slice(&(bytes[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Type))), 4))
+
+        impl.sendBytes(connection, bytes, flag)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flag,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        nil,
+    })
+}
+
+// an optional single non-zero byte in the request asks for the elements' authorship
+func (impl *SyncImpl) boardElements(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    withAuthorship := message.body != nil && len(message.body) == 1 && message.body[0] != 0
+    impl.sendElements(connection, impl.db.GetElements(client.board), withAuthorship, flagGetBoardElements)
+
+    return false
+}
+
+// the request consists of the moment (8 bytes) optionally followed by the authorship byte as for flagGetBoardElements,
+// replies the same way as for it but with the elements the board had at that moment
+func (impl *SyncImpl) boardElementsAt(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || (len(message.body) != 8 && len(message.body) != 9) { return true }
+
+    var timestamp uint64
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&timestamp)), 8), unsafe.Slice(&(message.body[0]), 8))
+
+    elements := impl.db.GetElementsAt(client.board, timestamp)
+    if elements == nil {
+        impl.network.sendMessage(connection, &Message{
+            flagGetBoardElementsAt,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+        return false
+    }
 
-    impl.db.AddElement(database.Element{database.ElementText, bytes}, client.board)
+    impl.sendElements(connection, elements, len(message.body) == 9 && message.body[8] != 0, flagGetBoardElementsAt)
     return false
 }
 
-func (impl *SyncImpl) image(connection net.Conn, message *Message) bool {
+// id - 4 bytes, timestamp - 8, creator - maxCredentialSize, then the name
+func (impl *SyncImpl) packCheckpoint(checkpoint *database.Checkpoint) []byte {
+    bytes := make([]byte, 4 + 8 + maxCredentialSize + len(checkpoint.Name))
+    copy(unsafe.Slice(&(bytes[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(checkpoint.Id))), 4))
+    copy(unsafe.Slice(&(bytes[4]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(checkpoint.Timestamp))), 8))
+    copy(unsafe.Slice(&(bytes[12]), maxCredentialSize), checkpoint.Username)
+    copy(bytes[12 + maxCredentialSize:], checkpoint.Name)
+    return bytes
+}
+
+// the request is the checkpoint's name, replies with the created checkpoint
+func (impl *SyncImpl) createCheckpoint(connection net.Conn, message *Message) bool {
     client := impl.clients.getClient(connection)
     if client == nil { return true }
+    if message.body == nil || len(message.body) > database.MaxCheckpointNameSize { return true }
 
-    bytes := impl.processPendingMessages(connection, message)
-    if bytes == nil { return false }
+    var result []byte
+    if checkpoint := impl.db.AddCheckpoint(client.Username, client.board, message.body); checkpoint != nil {
+        result = impl.packCheckpoint(checkpoint)
+    } else {
+        result = nil
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagCreateCheckpoint,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
 
-    impl.db.AddElement(database.Element{database.ElementImage, bytes}, client.board)
     return false
 }
 
-func (impl *SyncImpl) undo(connection net.Conn) bool {
+func (impl *SyncImpl) getCheckpoints(connection net.Conn) bool {
     client := impl.clients.getClient(connection)
     if client == nil { return true }
 
-    impl.db.RemoveLastElement(client.board)
+    checkpoints := impl.db.GetCheckpoints(client.board)
+
+    if len(checkpoints) == 0 {
+        impl.network.sendMessage(connection, &Message{
+            flagGetCheckpoints,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+    } else {
+        var index int32 = 0
+        timestamp := int64(utils.CurrentTimeMillis())
+
+        for _, checkpoint := range checkpoints {
+            impl.network.sendMessage(connection, &Message{
+                flagGetCheckpoints,
+                index,
+                int32(len(checkpoints)),
+                timestamp,
+                impl.packCheckpoint(checkpoint),
+            })
+            index++
+        }
+    }
+
     return false
 }
 
-func (impl *SyncImpl) clear(connection net.Conn) bool {
+func (impl *SyncImpl) restoreCheckpoint(connection net.Conn, message *Message) bool {
+    if message.body == nil || len(message.body) != 4 { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    return impl.applyChanges(connection, flagRestoreCheckpoint, func(client *Client) *database.Changes {
+        return impl.db.RestoreCheckpoint(client.Username, client.board, id)
+    })
+}
+
+// the request is the checkpoint's id optionally followed by the authorship byte, replies as for flagGetBoardElements, nothing gets changed
+func (impl *SyncImpl) checkpointElements(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || (len(message.body) != 4 && len(message.body) != 5) { return true }
+
+    var id int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var elements []*database.Element = nil
+    if checkpoint := impl.db.GetCheckpoint(client.board, id); checkpoint != nil {
+        elements = impl.db.GetElementsAt(client.board, checkpoint.Timestamp)
+    }
+
+    if elements == nil {
+        impl.network.sendMessage(connection, &Message{
+            flagGetCheckpointElements,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+        return false
+    }
+
+    impl.sendElements(connection, elements, len(message.body) == 5 && message.body[4] != 0, flagGetCheckpointElements)
+    return false
+}
+
+// id - 4 bytes, sequence - 8, z-order - 8, layer - 4, group - 4, author - maxCredentialSize, creation time - 8, type - 4, then the element's bytes
+func (impl *SyncImpl) packElementRecord(element *database.Element) []byte {
+    record := make([]byte, 4 + 8 + 8 + 4 + 4 + authorshipSize + 4 + len(element.Bytes))
+    copy(unsafe.Slice(&(record[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Id))), 4))
+    copy(unsafe.Slice(&(record[4]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Sequence))), 8))
+    copy(unsafe.Slice(&(record[12]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(element.ZOrder))), 8))
+    copy(unsafe.Slice(&(record[20]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Layer))), 4))
+    copy(unsafe.Slice(&(record[24]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Group))), 4))
+    impl.packAuthorship(element, record[28:])
+    copy(unsafe.Slice(&(record[28 + authorshipSize]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Type))), 4))
+    copy(record[32 + authorshipSize:], element.Bytes)
+    return record
+}
+
+// replies with a head (full snapshot or not - 1 byte, the board's latest sequence - 8 bytes),
+// then a record per changed element and per tombstone (whose type is tombstoneType), then an empty message
+func (impl *SyncImpl) boardElementsSince(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 8 { return true }
+
+    var sequence int64
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&sequence)), 8), unsafe.Slice(&(message.body[0]), 8))
+
+    changes := impl.db.GetChangesSince(client.board, sequence)
+    if changes == nil {
+        impl.network.sendMessage(connection, &Message{
+            flagGetBoardElementsSince,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+        return false
+    }
+
+    head := make([]byte, 1 + 8)
+    if changes.Full { head[0] = 1 }
+    copy(unsafe.Slice(&(head[1]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(changes.Sequence))), 8))
+
+    impl.network.sendMessage(connection, &Message{
+        flagGetBoardElementsSince,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        head,
+    })
+
+    for _, element := range changes.Elements {
+        impl.sendBytes(connection, impl.packElementRecord(element), flagGetBoardElementsSince)
+    }
+
+    for _, tombstone := range changes.Tombstones {
+        impl.sendBytes(connection, impl.packElementRecord(&database.Element{Id: tombstone.ElementId, Sequence: tombstone.Sequence, Type: tombstoneType}), flagGetBoardElementsSince)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagGetBoardElementsSince,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        nil,
+    })
+
+    return false
+}
+
+// id - 4 bytes, order - 4, visible - 1, locked - 1, then the name
+func (impl *SyncImpl) packLayer(layer *database.Layer) []byte {
+    bytes := make([]byte, 4 + 4 + 1 + 1 + len(layer.Name))
+    copy(unsafe.Slice(&(bytes[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(layer.Id))), 4))
+    copy(unsafe.Slice(&(bytes[4]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(layer.Order))), 4))
+    if layer.Visible { bytes[8] = 1 }
+    if layer.Locked { bytes[9] = 1 }
+    copy(bytes[10:], layer.Name)
+    return bytes
+}
+
+func (impl *SyncImpl) unpackLayer(bytes []byte) *database.Layer { // nillable
+    if len(bytes) < 10 || len(bytes) - 10 > database.MaxLayerNameSize { return nil }
+
+    layer := new(database.Layer)
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&(layer.Id))), 4), unsafe.Slice(&(bytes[0]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&(layer.Order))), 4), unsafe.Slice(&(bytes[4]), 4))
+    layer.Visible = bytes[8] != 0
+    layer.Locked = bytes[9] != 0
+
+    layer.Name = make([]byte, len(bytes) - 10)
+    copy(layer.Name, bytes[10:])
+
+    return layer
+}
+
+// the layer's id in the request is ignored, replies with the created layer
+func (impl *SyncImpl) createLayer(connection net.Conn, message *Message) bool {
     client := impl.clients.getClient(connection)
     if client == nil { return true }
 
-    impl.db.RemoveAllElements(client.board)
+    layer := impl.unpackLayer(message.body)
+    if layer == nil { return true }
+
+    var result []byte
+    if added := impl.db.AddLayer(client.board, layer); added != nil {
+        result = impl.packLayer(added)
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagCreateLayer,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
     return false
 }
 
-func (impl *SyncImpl) selectBoard(connection net.Conn, message *Message) bool {
+func (impl *SyncImpl) updateLayer(connection net.Conn, message *Message) bool {
     client := impl.clients.getClient(connection)
     if client == nil { return true }
 
+    layer := impl.unpackLayer(message.body)
+    if layer == nil { return true }
+
+    var result []byte
+    if impl.db.UpdateLayer(client.board, layer) {
+        result = []byte{1}
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagUpdateLayer,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) deleteLayer(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
+
     var id int32
-    copy(unsafe.Slice((*byte) (unsafe.Pointer(&(id))), 4), unsafe.Slice(&(message.body[0]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    var result []byte
+    if impl.db.RemoveLayer(client.board, id) {
+        result = []byte{1}
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
 
-    client.board = id
+    impl.network.sendMessage(connection, &Message{
+        flagDeleteLayer,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
 
     return false
 }
 
-func (impl *SyncImpl) boardElements(connection net.Conn) bool {
+func (impl *SyncImpl) getLayers(connection net.Conn) bool {
     client := impl.clients.getClient(connection)
     if client == nil { return true }
 
-    for _, element := range impl.db.GetElements(client.board) {
-        bytes := make([]byte, 4 + len(element.Bytes))
-        copy(unsafe.Slice(&(bytes[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(element.Type))), 4))
-        copy(unsafe.Slice(&(bytes[4]), len(element.Bytes)), element.Bytes)
+    layers := impl.db.GetLayers(client.board)
+
+    if len(layers) == 0 {
+        impl.network.sendMessage(connection, &Message{
+            flagGetLayers,
+            0,
+            1,
+            int64(utils.CurrentTimeMillis()),
+            nil,
+        })
+    } else {
+        var index int32 = 0
+        timestamp := int64(utils.CurrentTimeMillis())
+
+        for _, layer := range layers {
+            impl.network.sendMessage(connection, &Message{
+                flagGetLayers,
+                index,
+                int32(len(layers)),
+                timestamp,
+                impl.packLayer(layer),
+            })
+            index++
+        }
+    }
+
+    return false
+}
+
+// the request consists of the element's id followed by the layer's id, which is zero to take the element out of any layer
+func (impl *SyncImpl) moveToLayer(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 8 { return true }
+
+    var id, layer int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&id)), 4), unsafe.Slice(&(message.body[0]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&layer)), 4), unsafe.Slice(&(message.body[4]), 4))
+
+    var result []byte
+    if element := impl.db.MoveElementToLayer(client.board, id, layer); element != nil {
+        result = impl.packElementAck(element)
+        impl.broadcast(connection, client.board, impl.packElementRecord(element), flagElementUpdated)
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagMoveToLayer,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+// replies with a record per element of the layer (zero for elements outside of any), then an empty message
+func (impl *SyncImpl) layerElements(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+    if message.body == nil || len(message.body) != 4 { return true }
 
-        impl.sendBytes(connection, bytes, flagGetBoardElements)
+    var layer int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&layer)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    for _, element := range impl.db.GetLayerElements(client.board, layer) {
+        impl.sendBytes(connection, impl.packElementRecord(element), flagGetLayerElements)
     }
 
     impl.network.sendMessage(connection, &Message{
-        flagGetBoardElements,
+        flagGetLayerElements,
         0,
         1,
         int64(utils.CurrentTimeMillis()),
@@ -461,14 +2109,127 @@ func (impl *SyncImpl) boardElements(connection net.Conn) bool {
     return false
 }
 
+// the request is a list of element ids, replies with the new group's id (4 bytes) and the board's new sequence (8 bytes)
+func (impl *SyncImpl) groupElements(connection net.Conn, message *Message) bool {
+    client := impl.clients.getClient(connection)
+    if client == nil { return true }
+
+    bytes := impl.processPendingMessages(connection, message)
+    if bytes == nil { return false }
+    if len(bytes) % 4 != 0 { return true }
+
+    ids := make([]int32, len(bytes) / 4)
+    for index := range ids {
+        copy(unsafe.Slice((*byte) (unsafe.Pointer(&(ids[index]))), 4), unsafe.Slice(&(bytes[index * 4]), 4))
+    }
+
+    var result []byte
+    if changes := impl.db.GroupElements(client.board, ids); changes != nil {
+        result = make([]byte, 4 + 8)
+        copy(unsafe.Slice(&(result[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(changes.Elements[0].Group))), 4))
+        copy(unsafe.Slice(&(result[4]), 8), unsafe.Slice((*byte) (unsafe.Pointer(&(changes.Sequence))), 8))
+        impl.broadcastChanges(client.board, changes)
+    } else {
+        result = impl.failureReply(client, client.board)
+    }
+
+    impl.network.sendMessage(connection, &Message{
+        flagGroupElements,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        result,
+    })
+
+    return false
+}
+
+func (impl *SyncImpl) ungroup(connection net.Conn, message *Message) bool {
+    if message.body == nil || len(message.body) != 4 { return true }
+
+    var group int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&group)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    return impl.applyChanges(connection, flagUngroup, func(client *Client) *database.Changes {
+        return impl.db.Ungroup(client.board, group)
+    })
+}
+
+// the request consists of the group's id followed by the offsets along both axes (float32 each)
+func (impl *SyncImpl) moveGroup(connection net.Conn, message *Message) bool {
+    if message.body == nil || len(message.body) != 12 { return true }
+
+    var group int32
+    var dx, dy float32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&group)), 4), unsafe.Slice(&(message.body[0]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&dx)), 4), unsafe.Slice(&(message.body[4]), 4))
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&dy)), 4), unsafe.Slice(&(message.body[8]), 4))
+
+    return impl.applyChanges(connection, flagMoveGroup, func(client *Client) *database.Changes {
+        return impl.db.MoveGroup(client.Username, client.board, group, dx, dy)
+    })
+}
+
+func (impl *SyncImpl) deleteGroup(connection net.Conn, message *Message) bool {
+    if message.body == nil || len(message.body) != 4 { return true }
+
+    var group int32
+    copy(unsafe.Slice((*byte) (unsafe.Pointer(&group)), 4), unsafe.Slice(&(message.body[0]), 4))
+
+    return impl.applyChanges(connection, flagDeleteGroup, func(client *Client) *database.Changes {
+        return impl.db.RemoveGroup(client.Username, client.board, group)
+    })
+}
+
+func (impl *SyncImpl) throttle(connection net.Conn, message *Message) utils.Triple {
+    var username []byte = nil
+    if client := impl.clients.getClient(connection); client != nil { username = client.Username }
+
+    result := impl.limiter.allow(connection, username, message)
+    if result != utils.Neutral || message.index > 0 { return result } // only the first part of a throttled message gets a reply
+
+    body := make([]byte, 4)
+    copy(unsafe.Slice(&(body[0]), 4), unsafe.Slice((*byte) (unsafe.Pointer(&(message.flag))), 4))
+
+    impl.network.sendMessage(connection, &Message{
+        flagThrottled,
+        0,
+        1,
+        int64(utils.CurrentTimeMillis()),
+        body,
+    })
+
+    return result
+}
+
 func (impl *SyncImpl) routeMessage(connection net.Conn, message *Message) bool {
     disconnect := false
 
+    switch impl.throttle(connection, message) {
+        case utils.Neutral:
+            return false
+        case utils.Negative:
+            impl.clientDisconnected(connection)
+            return true
+    }
+
     switch message.flag {
         case flagLogIn:
             disconnect = impl.logIn(connection, message)
         case flagRegister:
             disconnect = impl.register(connection, message)
+        case flagResumeSession:
+            disconnect = impl.resumeSession(connection, message)
+        case flagLogOut:
+            disconnect = impl.logOut(connection)
+        case flagRevokeSessions:
+            disconnect = impl.revokeSessions(connection, message)
+        case flagDeleteAccount:
+            disconnect = impl.deleteAccount(connection, message)
+        case flagUnlockUser:
+            disconnect = impl.unlockUser(connection, message)
+        case flagUnlockAddress:
+            disconnect = impl.unlockAddress(connection, message)
         case flagShutdown:
             disconnect = impl.shutdown(connection)
         case flagCreateBoard:
@@ -477,8 +2238,48 @@ func (impl *SyncImpl) routeMessage(connection net.Conn, message *Message) bool {
             disconnect = impl.getBoard(connection, message)
         case flagGetBoards:
             disconnect = impl.getBoards(connection)
+        case flagListBoards:
+            disconnect = impl.listBoards(connection, message)
+        case flagUpdateBoard:
+            disconnect = impl.updateBoard(connection, message)
         case flagDeleteBoard:
             disconnect = impl.deleteBoard(connection, message)
+        case flagGetTrash:
+            disconnect = impl.getTrash(connection)
+        case flagRestoreBoard:
+            disconnect = impl.restoreBoard(connection, message)
+        case flagPurgeBoard:
+            disconnect = impl.purgeBoard(connection, message)
+        case flagTransferBoard:
+            disconnect = impl.transferBoard(connection, message)
+        case flagLockBoard:
+            disconnect = impl.lockBoard(connection, message)
+        case flagForkBoard:
+            disconnect = impl.forkBoard(connection, message)
+        case flagSetTemplate:
+            disconnect = impl.setTemplate(connection, message)
+        case flagGetTemplates:
+            disconnect = impl.getTemplates(connection)
+        case flagInstantiateTemplate:
+            disconnect = impl.instantiateTemplate(connection, message)
+        case flagCreateWorkspace:
+            disconnect = impl.createWorkspace(connection, message)
+        case flagGetWorkspaces:
+            disconnect = impl.getWorkspaces(connection)
+        case flagAddWorkspaceMember:
+            disconnect = impl.addWorkspaceMember(connection, message)
+        case flagCreateFolder:
+            disconnect = impl.createFolder(connection, message)
+        case flagRenameFolder:
+            disconnect = impl.renameFolder(connection, message)
+        case flagMoveFolder:
+            disconnect = impl.moveFolder(connection, message)
+        case flagGetFolders:
+            disconnect = impl.getFolders(connection, message)
+        case flagPlaceBoard:
+            disconnect = impl.placeBoard(connection, message)
+        case flagGetFolderBoards:
+            disconnect = impl.getFolderBoards(connection, message)
         case flagPointsSet:
             disconnect = impl.pointsSet(connection, message)
         case flagLine:
@@ -487,23 +2288,80 @@ func (impl *SyncImpl) routeMessage(connection net.Conn, message *Message) bool {
             disconnect = impl.text(connection, message)
         case flagImage:
             disconnect = impl.image(connection, message)
+        case flagRectangle:
+            disconnect = impl.rectangle(connection, message)
+        case flagEllipse:
+            disconnect = impl.ellipse(connection, message)
+        case flagArrow:
+            disconnect = impl.arrow(connection, message)
+        case flagStickyNote:
+            disconnect = impl.stickyNote(connection, message)
+        case flagAddElementWithKey:
+            disconnect = impl.addElementWithKey(connection, message)
+        case flagUpdateElement:
+            disconnect = impl.updateElement(connection, message)
+        case flagDeleteElement:
+            disconnect = impl.deleteElement(connection, message)
+        case flagBringToFront:
+            disconnect = impl.reorderElement(connection, message, true, flagBringToFront)
+        case flagSendToBack:
+            disconnect = impl.reorderElement(connection, message, false, flagSendToBack)
         case flagUndo:
             disconnect = impl.undo(connection)
+        case flagRedo:
+            disconnect = impl.redo(connection)
         case flagClear:
             disconnect = impl.clear(connection)
         case flagSelectBoard:
             disconnect = impl.selectBoard(connection, message)
         case flagGetBoardElements:
-            disconnect = impl.boardElements(connection)
+            disconnect = impl.boardElements(connection, message)
+        case flagGetBoardElementsAt:
+            disconnect = impl.boardElementsAt(connection, message)
+        case flagCreateCheckpoint:
+            disconnect = impl.createCheckpoint(connection, message)
+        case flagGetCheckpoints:
+            disconnect = impl.getCheckpoints(connection)
+        case flagRestoreCheckpoint:
+            disconnect = impl.restoreCheckpoint(connection, message)
+        case flagGetCheckpointElements:
+            disconnect = impl.checkpointElements(connection, message)
+        case flagGetBoardElementsSince:
+            disconnect = impl.boardElementsSince(connection, message)
+        case flagCreateLayer:
+            disconnect = impl.createLayer(connection, message)
+        case flagUpdateLayer:
+            disconnect = impl.updateLayer(connection, message)
+        case flagDeleteLayer:
+            disconnect = impl.deleteLayer(connection, message)
+        case flagGetLayers:
+            disconnect = impl.getLayers(connection)
+        case flagMoveToLayer:
+            disconnect = impl.moveToLayer(connection, message)
+        case flagGetLayerElements:
+            disconnect = impl.layerElements(connection, message)
+        case flagGroupElements:
+            disconnect = impl.groupElements(connection, message)
+        case flagUngroup:
+            disconnect = impl.ungroup(connection, message)
+        case flagMoveGroup:
+            disconnect = impl.moveGroup(connection, message)
+        case flagDeleteGroup:
+            disconnect = impl.deleteGroup(connection, message)
     }
 
     if disconnect {
-        impl.clients.removeClient(connection)
+        impl.clientDisconnected(connection)
     }
 
     return disconnect
 }
 
 func (impl *SyncImpl) clientDisconnected(connection net.Conn) {
+    if client := impl.clients.getClient(connection); client != nil && client.session != nil {
+        impl.sessions.detachSession(connection, client.session)
+    }
+
     impl.clients.removeClient(connection)
+    impl.limiter.forget(connection)
 }
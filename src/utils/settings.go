@@ -0,0 +1,54 @@
+/*
+ * JaonedServer - an online drawing board
+ * Copyright (C) 2024 Vadim Nikolaev (https://github.com/vadniks).
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package utils
+
+import (
+    "os"
+    "strconv"
+)
+
+// server settings come from the environment, unset ones fall back to the defaults
+
+func StringSetting(name string, fallback string) string {
+    value, exists := os.LookupEnv(name)
+    if !exists { return fallback }
+    return value
+}
+
+func IntSetting(name string, fallback int64) int64 {
+    value, exists := os.LookupEnv(name)
+    if !exists { return fallback }
+
+    parsed, err := strconv.ParseInt(value, 10, 64)
+    if err != nil { println("malformed setting " + name) }
+    Assert(err == nil)
+
+    return parsed
+}
+
+func FloatSetting(name string, fallback float64) float64 {
+    value, exists := os.LookupEnv(name)
+    if !exists { return fallback }
+
+    parsed, err := strconv.ParseFloat(value, 64)
+    if err != nil { println("malformed setting " + name) }
+    Assert(err == nil)
+
+    return parsed
+}